@@ -0,0 +1,142 @@
+package adapters
+
+import (
+	"encoding/json"
+	"imageResizerX/domain"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	recordsBucket = []byte("records")
+	// keysBucket maps content keys to the id of their latest record
+	keysBucket = []byte("keys")
+)
+
+// BoltRecordStore keeps the records in a single bbolt database file.
+type BoltRecordStore struct {
+	db *bbolt.DB
+}
+
+func NewBoltRecordStore(path string) (*BoltRecordStore, error) {
+	// the file is locked while open, give up instead of waiting on another
+	// process holding it
+	db, err := bbolt.Open(path, 0644, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(recordsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(keysBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltRecordStore{db: db}, nil
+}
+
+func (s *BoltRecordStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltRecordStore) Put(record domain.ImageRecord) error {
+	if err := validName(record.ID); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(recordsBucket).Put([]byte(record.ID), data); err != nil {
+			return err
+		}
+		return tx.Bucket(keysBucket).Put([]byte(record.ContentKey), []byte(record.ID))
+	})
+}
+
+func (s *BoltRecordStore) Get(id string) (domain.ImageRecord, error) {
+	var record domain.ImageRecord
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		record, err = getRecord(tx, []byte(id))
+		return err
+	})
+
+	return record, err
+}
+
+func (s *BoltRecordStore) FindByKey(contentKey string) (domain.ImageRecord, error) {
+	var record domain.ImageRecord
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		id := tx.Bucket(keysBucket).Get([]byte(contentKey))
+		if id == nil {
+			return domain.ErrRecordNotFound
+		}
+
+		var err error
+		record, err = getRecord(tx, id)
+		return err
+	})
+
+	return record, err
+}
+
+func (s *BoltRecordStore) Delete(id string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		record, err := getRecord(tx, []byte(id))
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(recordsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		keys := tx.Bucket(keysBucket)
+		if string(keys.Get([]byte(record.ContentKey))) == id {
+			return keys.Delete([]byte(record.ContentKey))
+		}
+		return nil
+	})
+}
+
+func (s *BoltRecordStore) List() ([]domain.ImageRecord, error) {
+	records := []domain.ImageRecord{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(recordsBucket).ForEach(func(id, data []byte) error {
+			var record domain.ImageRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+
+			records = append(records, record)
+			return nil
+		})
+	})
+
+	return records, err
+}
+
+func getRecord(tx *bbolt.Tx, id []byte) (domain.ImageRecord, error) {
+	data := tx.Bucket(recordsBucket).Get(id)
+	if data == nil {
+		return domain.ImageRecord{}, domain.ErrRecordNotFound
+	}
+
+	var record domain.ImageRecord
+	err := json.Unmarshal(data, &record)
+	return record, err
+}
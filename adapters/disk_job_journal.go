@@ -0,0 +1,213 @@
+package adapters
+
+import (
+	"encoding/json"
+	"errors"
+	"imageResizerX/domain"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const sourceExt = ".src"
+
+// DiskJobJournal writes every queued job down in dir, as a JSON file with
+// its state and parameters next to a file with its uploaded bytes. Both are
+// synced to disk before a job is queued, the source first, so a job whose
+// JSON file exists can always be run again.
+type DiskJobJournal struct {
+	dir  string
+	lock sync.Mutex
+}
+
+func NewDiskJobJournal(dir string) (*DiskJobJournal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	journal := &DiskJobJournal{dir: dir}
+	if err := journal.removeIncomplete(); err != nil {
+		return nil, err
+	}
+
+	return journal, nil
+}
+
+func (j *DiskJobJournal) paths(id string) (string, string, error) {
+	if err := validName(id); err != nil {
+		return "", "", err
+	}
+
+	base := filepath.Join(j.dir, id)
+	return base + sidecarExt, base + sourceExt, nil
+}
+
+// Put writes job and its source down.
+func (j *DiskJobJournal) Put(job domain.QueuedJob) error {
+	_, sourcePath, err := j.paths(job.ID)
+	if err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if err := writeSynced(sourcePath, job.Source); err != nil {
+		return err
+	}
+
+	return j.write(job)
+}
+
+// Update writes the new state of a job already put, its source is kept.
+func (j *DiskJobJournal) Update(job domain.QueuedJob) error {
+	jobPath, _, err := j.paths(job.ID)
+	if err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if _, err := os.Stat(jobPath); errors.Is(err, os.ErrNotExist) {
+		return domain.ErrQueuedJobNotFound
+	}
+
+	return j.write(job)
+}
+
+func (j *DiskJobJournal) write(job domain.QueuedJob) error {
+	jobPath, _, _ := j.paths(job.ID)
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return writeSynced(jobPath, data)
+}
+
+func (j *DiskJobJournal) Delete(id string) error {
+	jobPath, sourcePath, err := j.paths(id)
+	if err != nil {
+		return domain.ErrQueuedJobNotFound
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	// the job file goes first, a source left alone is removed on start
+	err = os.Remove(jobPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return domain.ErrQueuedJobNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(sourcePath)
+}
+
+// List returns the jobs written down with their source.
+func (j *DiskJobJournal) List() ([]domain.QueuedJob, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []domain.QueuedJob{}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), sidecarExt) {
+			continue
+		}
+
+		jobPath, sourcePath, err := j.paths(strings.TrimSuffix(entry.Name(), sidecarExt))
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(jobPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var job domain.QueuedJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			return nil, err
+		}
+
+		job.Source, err = os.ReadFile(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// removeIncomplete removes what a crash left half written: temporary files
+// and sources whose job was never written down.
+func (j *DiskJobJournal) removeIncomplete() error {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		switch {
+		case strings.HasSuffix(name, ".tmp"):
+		case strings.HasSuffix(name, sourceExt):
+			jobPath := filepath.Join(j.dir, strings.TrimSuffix(name, sourceExt)+sidecarExt)
+			if _, err := os.Stat(jobPath); err == nil {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(j.dir, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSynced writes data aside, syncs it to disk and renames it over
+// filePath, so a crash leaves either the old or the new content.
+func writeSynced(filePath string, data []byte) error {
+	tmp := filePath + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, filePath)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
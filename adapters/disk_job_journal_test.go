@@ -0,0 +1,84 @@
+package adapters
+
+import (
+	"encoding/json"
+	"imageResizerX/domain"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testQueuedJob(id string, queuedAt time.Time) domain.QueuedJob {
+	return domain.QueuedJob{
+		ID:       id,
+		Client:   "alice",
+		Priority: "batch",
+		Params:   json.RawMessage(`{"filename":"photo.jpg"}`),
+		Source:   []byte("source bytes"),
+		State:    domain.QueuedJobWaiting,
+		QueuedAt: queuedAt,
+	}
+}
+
+func TestDiskJobJournal(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	journal, err := NewDiskJobJournal(dir)
+	assert.NoError(err)
+
+	queuedAt := time.Date(2023, 11, 1, 10, 0, 0, 0, time.UTC)
+	job := testQueuedJob("a1", queuedAt)
+
+	assert.NoError(journal.Put(job))
+	assert.Error(journal.Put(testQueuedJob("../b2", queuedAt)))
+
+	job.State = domain.QueuedJobRunning
+	assert.NoError(journal.Update(job))
+	assert.ErrorIs(journal.Update(testQueuedJob("c3", queuedAt)), domain.ErrQueuedJobNotFound)
+
+	// a new journal on the same directory finds the job back
+	journal, err = NewDiskJobJournal(dir)
+	assert.NoError(err)
+
+	jobs, err := journal.List()
+	assert.NoError(err)
+	assert.Equal([]domain.QueuedJob{job}, jobs)
+
+	assert.NoError(journal.Delete("a1"))
+	assert.ErrorIs(journal.Delete("a1"), domain.ErrQueuedJobNotFound)
+
+	jobs, err = journal.List()
+	assert.NoError(err)
+	assert.Empty(jobs)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(err)
+	assert.Empty(entries)
+}
+
+func TestDiskJobJournalIncomplete(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	journal, err := NewDiskJobJournal(dir)
+	assert.NoError(err)
+	assert.NoError(journal.Put(testQueuedJob("a1", time.Now())))
+
+	// left by a run killed in the middle of a put
+	assert.NoError(os.WriteFile(filepath.Join(dir, "b2"+sourceExt), []byte("source"), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "c3"+sidecarExt+".tmp"), []byte("{"), 0644))
+
+	journal, err = NewDiskJobJournal(dir)
+	assert.NoError(err)
+	assert.NoFileExists(filepath.Join(dir, "b2"+sourceExt))
+	assert.NoFileExists(filepath.Join(dir, "c3"+sidecarExt+".tmp"))
+
+	jobs, err := journal.List()
+	assert.NoError(err)
+	assert.Len(jobs, 1)
+	assert.Equal("a1", jobs[0].ID)
+}
@@ -0,0 +1,177 @@
+package adapters
+
+import (
+	"encoding/json"
+	"errors"
+	"imageResizerX/domain"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const sidecarExt = ".json"
+
+// JSONRecordStore keeps every record as a JSON sidecar file named after the
+// image in dir. The records are indexed by content key in memory, the
+// index is rebuilt from the files on start.
+type JSONRecordStore struct {
+	dir   string
+	lock  sync.RWMutex
+	byKey map[string]domain.ImageRecord
+}
+
+func NewJSONRecordStore(dir string) (*JSONRecordStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	store := &JSONRecordStore{
+		dir:   dir,
+		byKey: make(map[string]domain.ImageRecord),
+	}
+
+	records, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		store.index(record)
+	}
+
+	return store, nil
+}
+
+func (s *JSONRecordStore) path(id string) (string, error) {
+	if err := validName(id); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, id+sidecarExt), nil
+}
+
+func (s *JSONRecordStore) Put(record domain.ImageRecord) error {
+	filePath, err := s.path(record.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// written aside and renamed so a crash never leaves half a record
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filePath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.index(record)
+	return nil
+}
+
+func (s *JSONRecordStore) Get(id string) (domain.ImageRecord, error) {
+	filePath, err := s.path(id)
+	if err != nil {
+		return domain.ImageRecord{}, domain.ErrRecordNotFound
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return readRecord(filePath)
+}
+
+func (s *JSONRecordStore) FindByKey(contentKey string) (domain.ImageRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.byKey[contentKey]
+	if !ok {
+		return domain.ImageRecord{}, domain.ErrRecordNotFound
+	}
+	return record, nil
+}
+
+func (s *JSONRecordStore) Delete(id string) error {
+	filePath, err := s.path(id)
+	if err != nil {
+		return domain.ErrRecordNotFound
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	record, err := readRecord(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filePath); err != nil {
+		return err
+	}
+
+	if s.byKey[record.ContentKey].ID == id {
+		delete(s.byKey, record.ContentKey)
+	}
+	return nil
+}
+
+func (s *JSONRecordStore) List() ([]domain.ImageRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]domain.ImageRecord, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), sidecarExt) {
+			continue
+		}
+
+		record, err := readRecord(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// index makes record the one found for its content key, unless a newer one
+// is already.
+func (s *JSONRecordStore) index(record domain.ImageRecord) {
+	if current, ok := s.byKey[record.ContentKey]; ok && current.CreatedAt.After(record.CreatedAt) {
+		return
+	}
+	s.byKey[record.ContentKey] = record
+}
+
+func readRecord(filePath string) (domain.ImageRecord, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return domain.ImageRecord{}, domain.ErrRecordNotFound
+	}
+	if err != nil {
+		return domain.ImageRecord{}, err
+	}
+
+	var record domain.ImageRecord
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
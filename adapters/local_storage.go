@@ -0,0 +1,278 @@
+package adapters
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"imageResizerX/domain"
+	"imageResizerX/logs"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type FileManager interface {
+	Open(filename string) error
+	Write(data []byte) (n int, err error)
+	Close() error
+}
+
+type fileManager struct {
+	sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func NewFileManager() *fileManager {
+	return &fileManager{
+		file:   nil,
+		writer: nil,
+	}
+}
+
+func (fm *fileManager) Open(filename string) error {
+	fm.Lock()
+	defer fm.Unlock()
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+
+	if err != nil {
+		return err
+	}
+
+	fm.file = file
+	fm.writer = bufio.NewWriter(file)
+
+	return nil
+}
+
+func (fm *fileManager) Write(data []byte) (n int, err error) {
+	fm.Lock()
+	defer fm.Unlock()
+	return fm.writer.Write(data)
+}
+
+func (fm *fileManager) Close() error {
+	fm.Lock()
+	defer fm.Unlock()
+
+	if fm.file != nil {
+		err := fm.writer.Flush()
+		if err != nil {
+			return err
+		}
+
+		err = fm.file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// partialDir is where the files being written are kept, under the root,
+// until they are complete. Its leftovers are removed on start.
+const partialDir = ".partial"
+
+// LocalStorage keeps the images as files in a directory of the local
+// filesystem.
+type LocalStorage struct {
+	root string
+	// fileManager returns a fresh FileManager per Save, variants of an
+	// upload are saved concurrently.
+	fileManager func() FileManager
+	encode      func(w io.Writer, img *domain.ImageResized) error
+	usage       usageCounter
+}
+
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	// files a previous run was killed in the middle of writing
+	if err := os.RemoveAll(filepath.Join(root, partialDir)); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, partialDir), 0755); err != nil {
+		return nil, err
+	}
+
+	return &LocalStorage{
+		root:        root,
+		fileManager: func() FileManager { return NewFileManager() },
+		encode:      encodeImage,
+	}, nil
+}
+
+func (s *LocalStorage) path(name string) (string, error) {
+	if err := validName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, name), nil
+}
+
+// Save writes img under partialDir and moves it to its file once complete,
+// so a file is never seen half written. A failed or cancelled save removes
+// what was written of it.
+func (s *LocalStorage) Save(ctx context.Context, img *domain.ImageResized) (domain.ObjectInfo, error) {
+	filePath, err := s.path(img.Name)
+	if err != nil {
+		return domain.ObjectInfo{}, err
+	}
+
+	partialPath := filepath.Join(s.root, partialDir, img.Name)
+
+	fileManager := s.fileManager()
+	err = fileManager.Open(partialPath)
+	if err != nil {
+		logs.Logger.Error("Failed to performe output file creation",
+			zap.Error(err),
+		)
+		return domain.ObjectInfo{}, unavailable(err)
+	}
+
+	backend := &backendWriter{w: fileManager}
+	out := newChecksumWriter(contextWriter{ctx: ctx, w: backend})
+	err = s.encode(out, img)
+	if backend.err != nil {
+		err = unavailable(backend.err)
+	}
+	if closeErr := fileManager.Close(); err == nil {
+		err = unavailable(closeErr)
+	}
+
+	// an image saved again replaces the previous file
+	stat, statErr := os.Stat(filePath)
+
+	if err == nil {
+		err = unavailable(os.Rename(partialPath, filePath))
+	}
+
+	if err != nil {
+		os.Remove(partialPath)
+		return domain.ObjectInfo{}, err
+	}
+
+	if statErr == nil {
+		s.usage.remove(stat.Size())
+	}
+	s.usage.add(out.size)
+
+	return domain.ObjectInfo{
+		Name:        img.Name,
+		Size:        out.size,
+		ContentType: contentType(img.Name),
+		ModTime:     time.Now(),
+		Checksum:    out.Checksum(),
+	}, nil
+}
+
+// Open returns the stored file, which is also an io.ReadSeeker.
+func (s *LocalStorage) Open(name string) (io.ReadCloser, domain.ObjectInfo, error) {
+	filePath, err := s.path(name)
+	if err != nil {
+		return nil, domain.ObjectInfo{}, domain.ErrObjectNotFound
+	}
+
+	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, domain.ObjectInfo{}, domain.ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, domain.ObjectInfo{}, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, domain.ObjectInfo{}, err
+	}
+
+	return file, objectInfo(stat), nil
+}
+
+func (s *LocalStorage) Stat(name string) (domain.ObjectInfo, error) {
+	filePath, err := s.path(name)
+	if err != nil {
+		return domain.ObjectInfo{}, domain.ErrObjectNotFound
+	}
+
+	stat, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return domain.ObjectInfo{}, domain.ErrObjectNotFound
+	}
+	if err != nil {
+		return domain.ObjectInfo{}, err
+	}
+
+	return objectInfo(stat), nil
+}
+
+func (s *LocalStorage) Delete(name string) error {
+	filePath, err := s.path(name)
+	if err != nil {
+		return domain.ErrObjectNotFound
+	}
+
+	stat, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return domain.ErrObjectNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return domain.ErrObjectNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	s.usage.remove(stat.Size())
+	return nil
+}
+
+func (s *LocalStorage) List() ([]domain.ObjectInfo, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]domain.ObjectInfo, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			// removed since the directory was read
+			continue
+		}
+
+		objects = append(objects, objectInfo(stat))
+	}
+
+	s.usage.recount(objects)
+	return objects, nil
+}
+
+func (s *LocalStorage) Usage() (domain.StorageUsage, error) {
+	return s.usage.get(s.List)
+}
+
+func objectInfo(stat fs.FileInfo) domain.ObjectInfo {
+	return domain.ObjectInfo{
+		Name:        stat.Name(),
+		Size:        stat.Size(),
+		ContentType: contentType(stat.Name()),
+		ModTime:     stat.ModTime(),
+	}
+}
@@ -0,0 +1,171 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"imageResizerX/domain"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testImage(name string) *domain.ImageResized {
+	return &domain.ImageResized{
+		Img:      image.NewNRGBA(image.Rect(0, 0, 20, 10)),
+		Name:     name,
+		Encoding: domain.EncodeOptions{Format: "png"},
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	assert := assert.New(t)
+
+	storage, err := NewLocalStorage(filepath.Join(t.TempDir(), "uploads"))
+	assert.NoError(err)
+
+	name := fmt.Sprintf("photo_%d.png", time.Now().Unix())
+	saved, err := storage.Save(context.Background(), testImage(name))
+	assert.NoError(err)
+
+	info, err := storage.Stat(name)
+	assert.NoError(err)
+	assert.Equal("image/png", info.ContentType)
+	assert.NotZero(info.Size)
+
+	body, info, err := storage.Open(name)
+	assert.NoError(err)
+	content, _ := io.ReadAll(body)
+	body.Close()
+	assert.Equal(info.Size, int64(len(content)))
+	assert.Equal(info.Size, saved.Size)
+	assert.Equal(hashHex(content), saved.Checksum)
+
+	objects, err := storage.List()
+	assert.NoError(err)
+	assert.Len(objects, 1)
+	assert.Equal(name, objects[0].Name)
+
+	usage, err := storage.Usage()
+	assert.NoError(err)
+	assert.Equal(domain.StorageUsage{Bytes: info.Size, Objects: 1}, usage)
+
+	assert.NoError(storage.Delete(name))
+	usage, _ = storage.Usage()
+	assert.Equal(domain.StorageUsage{}, usage)
+	_, err = storage.Stat(name)
+	assert.ErrorIs(err, domain.ErrObjectNotFound)
+	assert.ErrorIs(storage.Delete(name), domain.ErrObjectNotFound)
+}
+
+func TestLocalStorageInvalidNames(t *testing.T) {
+	assert := assert.New(t)
+	root := t.TempDir()
+
+	storage, err := NewLocalStorage(filepath.Join(root, "uploads"))
+	assert.NoError(err)
+	assert.NoError(os.WriteFile(filepath.Join(root, "secret.png"), []byte("secret"), 0644))
+
+	_, err = storage.Save(context.Background(), testImage("../escape.png"))
+	assert.Error(err)
+
+	_, _, err = storage.Open("../secret.png")
+	assert.ErrorIs(err, domain.ErrObjectNotFound)
+
+	_, err = storage.Stat("..")
+	assert.ErrorIs(err, domain.ErrObjectNotFound)
+}
+
+func TestLocalStorageSaveCancelled(t *testing.T) {
+	assert := assert.New(t)
+	root := t.TempDir()
+
+	storage, err := NewLocalStorage(root)
+	assert.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = storage.Save(ctx, testImage("a.png"))
+	assert.ErrorIs(err, context.Canceled)
+	assert.NoFileExists(filepath.Join(root, "a.png"))
+	assert.NoFileExists(filepath.Join(root, partialDir, "a.png"))
+
+	usage, _ := storage.Usage()
+	assert.Equal(domain.StorageUsage{}, usage)
+}
+
+func TestLocalStorageSaveFailures(t *testing.T) {
+	assert := assert.New(t)
+	root := t.TempDir()
+
+	storage, err := NewLocalStorage(root)
+	assert.NoError(err)
+
+	storage.encode = func(w io.Writer, img *domain.ImageResized) error {
+		return fmt.Errorf("unsupported format %q", img.Encoding.Format)
+	}
+	_, err = storage.Save(context.Background(), testImage("a.png"))
+	assert.Error(err)
+	assert.NotErrorIs(err, domain.ErrStorageUnavailable)
+
+	// the directory is gone, the disk may come back
+	assert.NoError(os.RemoveAll(filepath.Join(root, partialDir)))
+	_, err = storage.Save(context.Background(), testImage("b.png"))
+	assert.ErrorIs(err, domain.ErrStorageUnavailable)
+}
+
+func TestLocalStoragePartialFiles(t *testing.T) {
+	assert := assert.New(t)
+	root := t.TempDir()
+
+	// left by a run killed in the middle of a save
+	assert.NoError(os.MkdirAll(filepath.Join(root, partialDir), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(root, partialDir, "a.png"), []byte("trunc"), 0644))
+
+	storage, err := NewLocalStorage(root)
+	assert.NoError(err)
+	assert.NoFileExists(filepath.Join(root, partialDir, "a.png"))
+
+	_, err = storage.Save(context.Background(), testImage("b.png"))
+	assert.NoError(err)
+
+	objects, err := storage.List()
+	assert.NoError(err)
+	assert.Len(objects, 1)
+	assert.Equal("b.png", objects[0].Name)
+}
+
+func TestLocalStorageUsage(t *testing.T) {
+	assert := assert.New(t)
+	root := t.TempDir()
+
+	assert.NoError(os.WriteFile(filepath.Join(root, "before.png"), make([]byte, 100), 0644))
+
+	storage, err := NewLocalStorage(root)
+	assert.NoError(err)
+	storage.encode = fixedSizeEncode(10)
+
+	// counted from the directory the first time
+	usage, err := storage.Usage()
+	assert.NoError(err)
+	assert.Equal(domain.StorageUsage{Bytes: 100, Objects: 1}, usage)
+
+	storage.Save(context.Background(), testImage("a.png"))
+	storage.Save(context.Background(), testImage("a.png"))
+	storage.Save(context.Background(), testImage("b.png"))
+	assert.NoError(storage.Delete("before.png"))
+
+	usage, _ = storage.Usage()
+	assert.Equal(domain.StorageUsage{Bytes: 20, Objects: 2}, usage)
+
+	// removed behind its back, corrected by the next listing
+	assert.NoError(os.Remove(filepath.Join(root, "b.png")))
+	storage.List()
+	usage, _ = storage.Usage()
+	assert.Equal(domain.StorageUsage{Bytes: 10, Objects: 1}, usage)
+}
@@ -0,0 +1,88 @@
+package adapters
+
+import (
+	"imageResizerX/domain"
+	"sync"
+)
+
+// MemoryRecordStore keeps the records in memory only, next to the memory
+// storage, so neither needs a writable filesystem. Restarting the service
+// loses every record.
+type MemoryRecordStore struct {
+	lock    sync.RWMutex
+	records map[string]domain.ImageRecord
+	byKey   map[string]domain.ImageRecord
+}
+
+func NewMemoryRecordStore() *MemoryRecordStore {
+	return &MemoryRecordStore{
+		records: make(map[string]domain.ImageRecord),
+		byKey:   make(map[string]domain.ImageRecord),
+	}
+}
+
+func (s *MemoryRecordStore) Put(record domain.ImageRecord) error {
+	if err := validName(record.ID); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.records[record.ID] = record
+
+	// the record found for a content key is the newest one
+	if current, ok := s.byKey[record.ContentKey]; !ok || !current.CreatedAt.After(record.CreatedAt) {
+		s.byKey[record.ContentKey] = record
+	}
+	return nil
+}
+
+func (s *MemoryRecordStore) Get(id string) (domain.ImageRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return domain.ImageRecord{}, domain.ErrRecordNotFound
+	}
+	return record, nil
+}
+
+func (s *MemoryRecordStore) FindByKey(contentKey string) (domain.ImageRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.byKey[contentKey]
+	if !ok {
+		return domain.ImageRecord{}, domain.ErrRecordNotFound
+	}
+	return record, nil
+}
+
+func (s *MemoryRecordStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return domain.ErrRecordNotFound
+	}
+
+	delete(s.records, id)
+	if s.byKey[record.ContentKey].ID == id {
+		delete(s.byKey, record.ContentKey)
+	}
+	return nil
+}
+
+func (s *MemoryRecordStore) List() ([]domain.ImageRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	records := make([]domain.ImageRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
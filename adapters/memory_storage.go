@@ -1,182 +1,177 @@
 package adapters
 
 import (
-	"bufio"
-	"errors"
-	"image"
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
 	"imageResizerX/domain"
 	"imageResizerX/logs"
 	"io"
-	"os"
-	"path/filepath"
 	"sync"
+	"time"
 
-	"github.com/disintegration/imaging"
 	"go.uber.org/zap"
 )
 
-type FileManager interface {
-	Open(filename string) error
-	Write(data []byte) (n int, err error)
-	Close() error
+type memoryEntry struct {
+	info    domain.ObjectInfo
+	data    []byte
+	element *list.Element
 }
 
-type fileManager struct {
-	sync.Mutex
-	file   *os.File
-	writer *bufio.Writer
+// StorageInMemory keeps the encoded images in RAM. Once the total size goes
+// over budget the least recently opened images are evicted.
+type StorageInMemory struct {
+	lock    sync.Mutex
+	entries map[string]*memoryEntry
+	// recency holds the entry names, most recently used first.
+	recency *list.List
+	size    int64
+	budget  int64
+	now     func() time.Time
+	encode  func(w io.Writer, img *domain.ImageResized) error
 }
 
-func NewFileManager() *fileManager {
-	return &fileManager{
-		file:   nil,
-		writer: nil,
+func NewStorageInMemory(budget int64) (*StorageInMemory, error) {
+	if budget <= 0 {
+		return nil, fmt.Errorf("memory storage budget must be positive, got %d", budget)
 	}
-}
-
-func (fm *fileManager) Open(filename string) error {
-	fm.Lock()
-	defer fm.Unlock()
-
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0666)
-
-	if err != nil {
-		return err
-	}
-
-	fm.file = file
-	fm.writer = bufio.NewWriter(file)
 
-	return nil
+	return &StorageInMemory{
+		entries: make(map[string]*memoryEntry),
+		recency: list.New(),
+		budget:  budget,
+		now:     time.Now,
+		encode:  encodeImage,
+	}, nil
 }
 
-func (fm *fileManager) Write(data []byte) (n int, err error) {
-	fm.Lock()
-	defer fm.Unlock()
-	return fm.writer.Write(data)
+// memoryReader lets the stored bytes be served with range requests.
+type memoryReader struct {
+	*bytes.Reader
 }
 
-func (fm *fileManager) Close() error {
-	fm.Lock()
-	defer fm.Unlock()
-
-	if fm.file != nil {
-		err := fm.writer.Flush()
-		if err != nil {
-			return err
-		}
+func (memoryReader) Close() error { return nil }
 
-		err = fm.file.Close()
-		if err != nil {
-			return err
-		}
+func (s *StorageInMemory) Save(ctx context.Context, img *domain.ImageResized) (domain.ObjectInfo, error) {
+	if err := validName(img.Name); err != nil {
+		return domain.ObjectInfo{}, err
 	}
 
-	return nil
-}
-
-type StorageInMemory struct {
-	localStorage string
-	fileManager  FileManager
-	sweepCh      chan struct{}
-	encode       func(file io.Writer, img *image.NRGBA, imgFormat string) error
-}
-
-func NewStorageInMemory() *StorageInMemory {
-	repo := &StorageInMemory{
-		localStorage: "uploads",
-		fileManager:  NewFileManager(),
-		sweepCh:      make(chan struct{}, 1),
-		encode: func(file io.Writer, img *image.NRGBA, imgFormat string) error {
+	var buf bytes.Buffer
+	out := newChecksumWriter(contextWriter{ctx: ctx, w: &buf})
+	if err := s.encode(out, img); err != nil {
+		return domain.ObjectInfo{}, err
+	}
 
-			f := map[string]imaging.Format{
-				"jpeg": imaging.JPEG,
-				"png":  imaging.PNG,
-			}[imgFormat]
+	data := buf.Bytes()
+	if int64(len(data)) > s.budget {
+		return domain.ObjectInfo{}, fmt.Errorf("image of %d bytes does not fit the memory budget of %d bytes", len(data), s.budget)
+	}
 
-			err := imaging.Encode(file, img, f)
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-			if err != nil {
-				logs.Logger.Error("Failed to performe image encode",
-					zap.Error(err),
-				)
-				return err
-			}
+	s.remove(img.Name)
 
-			return nil
+	entry := &memoryEntry{
+		info: domain.ObjectInfo{
+			Name:        img.Name,
+			Size:        int64(len(data)),
+			ContentType: contentType(img.Name),
+			ModTime:     s.now(),
 		},
+		data: data,
 	}
+	entry.element = s.recency.PushFront(img.Name)
+	s.entries[img.Name] = entry
+	s.size += entry.info.Size
+
+	s.evict()
 
-	repo.sweep()
-	return repo
+	info := entry.info
+	info.Checksum = out.Checksum()
+	return info, nil
 }
 
-func (s *StorageInMemory) Save(img *domain.ImageResized) error {
-	err := s.fileManager.Open(filepath.Join(s.localStorage, img.Name))
-	defer s.fileManager.Close()
-	if err != nil {
-		logs.Logger.Error("Failed to performe output file creation",
-			zap.Error(err),
-		)
-		return err
-	}
+func (s *StorageInMemory) Open(name string) (io.ReadCloser, domain.ObjectInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	select {
-	case s.sweepCh <- struct{}{}:
-	default:
+	entry, ok := s.entries[name]
+	if !ok {
+		return nil, domain.ObjectInfo{}, domain.ErrObjectNotFound
 	}
 
-	return s.encode(s.fileManager, img.Img, img.Format)
+	s.recency.MoveToFront(entry.element)
+	return memoryReader{bytes.NewReader(entry.data)}, entry.info, nil
 }
 
-func (s *StorageInMemory) Retrieve(filename string) (*domain.MemoryImg, error) {
-	filePath := filepath.Join(s.localStorage, filename)
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		logs.Logger.Error("File not found")
-		return nil, errors.New("file not found")
+func (s *StorageInMemory) Stat(name string) (domain.ObjectInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry, ok := s.entries[name]
+	if !ok {
+		return domain.ObjectInfo{}, domain.ErrObjectNotFound
 	}
 
-	return &domain.MemoryImg{FilePath: filePath}, nil
+	return entry.info, nil
 }
 
-func (s *StorageInMemory) clean() {
-	dir, err := os.Open(s.localStorage)
+func (s *StorageInMemory) Delete(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	if err != nil {
-		logs.Logger.Error("Dir not found")
-		return
+	if !s.remove(name) {
+		return domain.ErrObjectNotFound
 	}
+	return nil
+}
 
-	files, err := dir.ReadDir(0)
+func (s *StorageInMemory) List() ([]domain.ObjectInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	if err != nil {
-		logs.Logger.Error(err.Error())
-		return
+	objects := make([]domain.ObjectInfo, 0, len(s.entries))
+	for e := s.recency.Front(); e != nil; e = e.Next() {
+		objects = append(objects, s.entries[e.Value.(string)].info)
 	}
 
-	for i := range files {
-		file := files[i]
-		img := &domain.MemoryImg{FilePath: file.Name()}
+	return objects, nil
+}
 
-		if img.IsValid() {
-			continue
-		}
-		err := os.Remove(s.localStorage + "/" + img.FilePath)
+func (s *StorageInMemory) Usage() (domain.StorageUsage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-		if err != nil {
-			logs.Logger.Error(err.Error())
-		}
+	return domain.StorageUsage{Bytes: s.size, Objects: len(s.entries), Budget: s.budget}, nil
+}
 
+func (s *StorageInMemory) remove(name string) bool {
+	entry, ok := s.entries[name]
+	if !ok {
+		return false
 	}
 
+	s.recency.Remove(entry.element)
+	delete(s.entries, name)
+	s.size -= entry.info.Size
+	return true
 }
 
-func (s *StorageInMemory) sweep() {
-	go func() {
-		for {
-			<-s.sweepCh
-			s.clean()
-		}
-
-	}()
+// evict removes the least recently used entries until the budget is met.
+func (s *StorageInMemory) evict() {
+	for s.size > s.budget {
+		oldest := s.recency.Back()
+		name := oldest.Value.(string)
+		s.remove(name)
+
+		logs.Logger.Info("Evicted image from memory storage",
+			zap.String("filename", name),
+			zap.Int64("bytes", s.size),
+			zap.Int64("budget", s.budget),
+		)
+	}
 }
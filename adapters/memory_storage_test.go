@@ -0,0 +1,117 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"imageResizerX/domain"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedSizeEncode writes size bytes for every image, whatever its content.
+func fixedSizeEncode(size int) func(w io.Writer, img *domain.ImageResized) error {
+	return func(w io.Writer, img *domain.ImageResized) error {
+		_, err := w.Write(make([]byte, size))
+		return err
+	}
+}
+
+func TestStorageInMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	storage, err := NewStorageInMemory(1 << 20)
+	assert.NoError(err)
+
+	saved, err := storage.Save(context.Background(), testImage("photo_1.png"))
+	assert.NoError(err)
+
+	body, info, err := storage.Open("photo_1.png")
+	assert.NoError(err)
+	content, _ := io.ReadAll(body)
+	body.Close()
+	assert.Equal(info.Size, int64(len(content)))
+	assert.Equal("image/png", info.ContentType)
+	assert.Equal(hashHex(content), saved.Checksum)
+
+	usage, err := storage.Usage()
+	assert.NoError(err)
+	assert.Equal(info.Size, usage.Bytes)
+	assert.Equal(1, usage.Objects)
+
+	assert.NoError(storage.Delete("photo_1.png"))
+	_, err = storage.Stat("photo_1.png")
+	assert.ErrorIs(err, domain.ErrObjectNotFound)
+	usage, _ = storage.Usage()
+	assert.Equal(domain.StorageUsage{Budget: 1 << 20}, usage)
+}
+
+func TestStorageInMemoryEviction(t *testing.T) {
+	assert := assert.New(t)
+
+	storage, _ := NewStorageInMemory(300)
+	storage.encode = fixedSizeEncode(100)
+
+	for _, name := range []string{"a_1.png", "b_1.png", "c_1.png"} {
+		_, err := storage.Save(context.Background(), testImage(name))
+		assert.NoError(err)
+	}
+
+	// a becomes the most recently used, b the least
+	body, _, err := storage.Open("a_1.png")
+	assert.NoError(err)
+	body.Close()
+
+	_, err = storage.Save(context.Background(), testImage("d_1.png"))
+	assert.NoError(err)
+
+	_, err = storage.Stat("b_1.png")
+	assert.ErrorIs(err, domain.ErrObjectNotFound)
+
+	objects, _ := storage.List()
+	names := []string{}
+	for _, object := range objects {
+		names = append(names, object.Name)
+	}
+	assert.Equal([]string{"d_1.png", "a_1.png", "c_1.png"}, names)
+	usage, _ := storage.Usage()
+	assert.Equal(int64(300), usage.Bytes)
+
+	storage.encode = fixedSizeEncode(301)
+	_, err = storage.Save(context.Background(), testImage("huge_1.png"))
+	assert.Error(err)
+	// saving it again would not help
+	assert.NotErrorIs(err, domain.ErrStorageUnavailable)
+}
+
+func TestStorageInMemoryConcurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	storage, _ := NewStorageInMemory(1000)
+	storage.encode = fixedSizeEncode(100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("photo%d_1.png", i)
+			storage.Save(context.Background(), testImage(name))
+			if body, _, err := storage.Open(name); err == nil {
+				body.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	usage, _ := storage.Usage()
+	assert.Equal(int64(1000), usage.Bytes)
+	assert.Equal(10, usage.Objects)
+}
+
+func TestNewStorageInMemory(t *testing.T) {
+	_, err := NewStorageInMemory(0)
+	assert.Error(t, err)
+}
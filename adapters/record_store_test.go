@@ -0,0 +1,124 @@
+package adapters
+
+import (
+	"imageResizerX/domain"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordStore interface {
+	Put(record domain.ImageRecord) error
+	Get(id string) (domain.ImageRecord, error)
+	FindByKey(contentKey string) (domain.ImageRecord, error)
+	Delete(id string) error
+	List() ([]domain.ImageRecord, error)
+}
+
+func testRecord(id string, key string, createdAt time.Time) domain.ImageRecord {
+	expiresAt := createdAt.Add(domain.ImageLifetime)
+
+	return domain.ImageRecord{
+		ID:               id,
+		ContentKey:       key,
+		OriginalFilename: "photo.jpg",
+		DownloadFilename: "photo_thumb.png",
+		Variant:          "thumb",
+		SourceWidth:      400,
+		SourceHeight:     200,
+		Width:            100,
+		Height:           50,
+		Format:           "png",
+		Size:             1234,
+		Checksum:         "9f86d081884c7d65",
+		Operations:       []byte(`{"width":100}`),
+		CreatedAt:        createdAt,
+		ExpiresAt:        &expiresAt,
+	}
+}
+
+func TestRecordStores(t *testing.T) {
+	type testCase struct {
+		name string
+		open func(dir string) (recordStore, error)
+	}
+
+	for _, scenario := range []testCase{
+		{
+			name: "json",
+			open: func(dir string) (recordStore, error) { return NewJSONRecordStore(filepath.Join(dir, "records")) },
+		},
+		{
+			name: "memory",
+			open: func(dir string) (recordStore, error) { return NewMemoryRecordStore(), nil },
+		},
+		{
+			name: "bolt",
+			open: func(dir string) (recordStore, error) {
+				store, err := NewBoltRecordStore(filepath.Join(dir, "records.db"))
+				if err == nil {
+					t.Cleanup(func() { store.Close() })
+				}
+				return store, err
+			},
+		},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			store, err := scenario.open(t.TempDir())
+			assert.NoError(err)
+
+			createdAt := time.Date(2023, 11, 1, 10, 0, 0, 0, time.UTC)
+			first := testRecord("a1.png", "key", createdAt)
+			second := testRecord("b2.png", "key", createdAt.Add(time.Minute))
+
+			assert.NoError(store.Put(first))
+			assert.NoError(store.Put(second))
+			assert.Error(store.Put(testRecord("../c3.png", "other", createdAt)))
+
+			record, err := store.Get("a1.png")
+			assert.NoError(err)
+			assert.Equal(first, record)
+
+			record, err = store.FindByKey("key")
+			assert.NoError(err)
+			assert.Equal("b2.png", record.ID)
+
+			records, err := store.List()
+			assert.NoError(err)
+			assert.Len(records, 2)
+
+			assert.NoError(store.Delete("b2.png"))
+			_, err = store.FindByKey("key")
+			assert.ErrorIs(err, domain.ErrRecordNotFound)
+			_, err = store.Get("b2.png")
+			assert.ErrorIs(err, domain.ErrRecordNotFound)
+			assert.ErrorIs(store.Delete("b2.png"), domain.ErrRecordNotFound)
+
+			assert.NoError(store.Delete("a1.png"))
+			records, err = store.List()
+			assert.NoError(err)
+			assert.Empty(records)
+		})
+	}
+}
+
+func TestJSONRecordStoreReopen(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	store, err := NewJSONRecordStore(dir)
+	assert.NoError(err)
+	assert.NoError(store.Put(testRecord("a1.png", "key", time.Now())))
+
+	store, err = NewJSONRecordStore(dir)
+	assert.NoError(err)
+
+	record, err := store.FindByKey("key")
+	assert.NoError(err)
+	assert.Equal("a1.png", record.ID)
+	assert.FileExists(filepath.Join(dir, "a1.png.json"))
+}
@@ -0,0 +1,297 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"imageResizerX/config"
+	"imageResizerX/domain"
+	"imageResizerX/logs"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// s3RequestTimeout bounds the requests whose response is read right away.
+const s3RequestTimeout = time.Second * 30
+
+// S3Storage keeps the images as objects of a bucket of an S3 compatible
+// store, such as AWS S3 or MinIO. Requests use path style urls,
+// <endpoint>/<bucket>/<prefix><name>.
+type S3Storage struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	signer   sigV4Signer
+	client   *http.Client
+	now      func() time.Time
+	encode   func(w io.Writer, img *domain.ImageResized) error
+	usage    usageCounter
+}
+
+func NewS3Storage(cfg config.S3Config) (*S3Storage, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+
+	// only the wait for the headers is bounded on the client, a timeout of the
+	// whole request would cut off the downloads streamed from Open
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = s3RequestTimeout
+
+	return &S3Storage{
+		endpoint: endpoint,
+		bucket:   cfg.Bucket,
+		prefix:   cfg.Prefix,
+		signer: sigV4Signer{
+			accessKey: cfg.AccessKey,
+			secretKey: cfg.SecretKey,
+			region:    cfg.Region,
+			service:   "s3",
+		},
+		client: &http.Client{Transport: transport},
+		now:    time.Now,
+		encode: encodeImage,
+	}, nil
+}
+
+// s3Error is the error document S3 answers with.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do signs and sends a request on key, or on the bucket when key is empty.
+func (s *S3Storage) do(ctx context.Context, method string, key string, query map[string]string, body []byte) (*http.Response, error) {
+	path := strings.TrimSuffix(s.endpoint.Path, "/") + "/" + s.bucket + "/"
+	if key != "" {
+		path += s.prefix + key
+	}
+
+	target := *s.endpoint
+	target.Path = path
+	target.RawPath = uriEncode(path, true)
+	target.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		payloadHash = hashHex(body)
+		req.ContentLength = int64(len(body))
+		req.Header.Set("Content-Type", contentType(key))
+	}
+
+	s.signer.Sign(req, payloadHash, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		resp.Body.Close()
+		return nil, domain.ErrObjectNotFound
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		var doc s3Error
+		xml.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&doc)
+		err := fmt.Errorf("s3 %s %s: %s %s", method, path, resp.Status, doc.Code)
+
+		// the request itself is wrong for the other statuses
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			err = unavailable(err)
+		}
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (s *S3Storage) Save(ctx context.Context, img *domain.ImageResized) (domain.ObjectInfo, error) {
+	if err := validName(img.Name); err != nil {
+		return domain.ObjectInfo{}, err
+	}
+
+	var body bytes.Buffer
+	if err := s.encode(contextWriter{ctx: ctx, w: &body}, img); err != nil {
+		return domain.ObjectInfo{}, err
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, img.Name, nil, body.Bytes())
+	if err != nil {
+		logs.Logger.Error("Failed to perform object upload",
+			zap.Error(err),
+		)
+		return domain.ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	// names are random ids, an object is never saved over
+	s.usage.add(int64(body.Len()))
+
+	return domain.ObjectInfo{
+		Name:        img.Name,
+		Size:        int64(body.Len()),
+		ContentType: contentType(img.Name),
+		ModTime:     s.now(),
+		Checksum:    hashHex(body.Bytes()),
+	}, nil
+}
+
+func (s *S3Storage) objectInfo(name string, header http.Header) domain.ObjectInfo {
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	modTime, _ := http.ParseTime(header.Get("Last-Modified"))
+
+	info := domain.ObjectInfo{
+		Name:        name,
+		Size:        size,
+		ContentType: header.Get("Content-Type"),
+		ModTime:     modTime,
+	}
+
+	if info.ContentType == "" {
+		info.ContentType = contentType(name)
+	}
+
+	return info
+}
+
+func (s *S3Storage) Open(name string) (io.ReadCloser, domain.ObjectInfo, error) {
+	if validName(name) != nil {
+		return nil, domain.ObjectInfo{}, domain.ErrObjectNotFound
+	}
+
+	resp, err := s.do(context.Background(), http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, domain.ObjectInfo{}, err
+	}
+
+	return resp.Body, s.objectInfo(name, resp.Header), nil
+}
+
+func (s *S3Storage) Stat(name string) (domain.ObjectInfo, error) {
+	if validName(name) != nil {
+		return domain.ObjectInfo{}, domain.ErrObjectNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	resp, err := s.do(ctx, http.MethodHead, name, nil, nil)
+	if err != nil {
+		return domain.ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	return s.objectInfo(name, resp.Header), nil
+}
+
+// Delete removes name. S3 does not tell whether the object existed, so it is
+// looked up first to know the space freed.
+func (s *S3Storage) Delete(name string) error {
+	if validName(name) != nil {
+		return domain.ErrObjectNotFound
+	}
+
+	info, err := s.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	resp, err := s.do(ctx, http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	s.usage.remove(info.Size)
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Storage) List() ([]domain.ObjectInfo, error) {
+	objects := []domain.ObjectInfo{}
+	query := map[string]string{"list-type": "2", "prefix": s.prefix}
+
+	for {
+		result, err := s.listPage(query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			name := strings.TrimPrefix(content.Key, s.prefix)
+			if validName(name) != nil {
+				continue
+			}
+
+			objects = append(objects, domain.ObjectInfo{
+				Name:        name,
+				Size:        content.Size,
+				ContentType: contentType(name),
+				ModTime:     content.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			s.usage.recount(objects)
+			return objects, nil
+		}
+
+		query["continuation-token"] = result.NextContinuationToken
+	}
+}
+
+// listPage fetches one page of the bucket listing, bounded by
+// s3RequestTimeout.
+func (s *S3Storage) listPage(query map[string]string) (listBucketResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	var result listBucketResult
+
+	resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	return result, err
+}
+
+// Usage lists the whole bucket prefix the first time it is called.
+func (s *S3Storage) Usage() (domain.StorageUsage, error) {
+	return s.usage.get(s.List)
+}
@@ -0,0 +1,212 @@
+package adapters
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"imageResizerX/config"
+	"imageResizerX/domain"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// s3StandIn is a minimal MinIO-like server holding one bucket in memory. It
+// checks the signature of every request and pages listings by two keys.
+type s3StandIn struct {
+	bucket  string
+	signer  sigV4Signer
+	objects map[string][]byte
+	lock    sync.Mutex
+}
+
+func newS3StandIn(t *testing.T, bucket string) (*s3StandIn, *httptest.Server) {
+	standIn := &s3StandIn{
+		bucket:  bucket,
+		signer:  sigV4Signer{accessKey: "minio", secretKey: "minio-secret", region: "us-east-1", service: "s3"},
+		objects: map[string][]byte{},
+	}
+
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	return standIn, server
+}
+
+func (s *s3StandIn) verify(r *http.Request) bool {
+	date, err := time.Parse(amzDateFormat, r.Header.Get("x-amz-date"))
+	if err != nil {
+		return false
+	}
+
+	expected, _ := http.NewRequest(r.Method, "http://"+r.Host+r.RequestURI, nil)
+	s.signer.Sign(expected, r.Header.Get("x-amz-content-sha256"), date)
+
+	return expected.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.verify(r) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>")
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+s.bucket+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<Error><Code>NoSuchBucket</Code></Error>")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if key == "" {
+		s.list(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if hashHex(body) != r.Header.Get("x-amz-content-sha256") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = body
+	case http.MethodGet, http.MethodHead:
+		body, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Write(body)
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *s3StandIn) list(w http.ResponseWriter, r *http.Request) {
+	keys := []string{}
+	for key := range s.objects {
+		if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	if token := r.URL.Query().Get("continuation-token"); token != "" {
+		start, _ := strconv.Atoi(token)
+		keys = keys[start:]
+	}
+
+	var result listBucketResult
+	if len(keys) > 2 {
+		start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(start + 2)
+		keys = keys[:2]
+	}
+
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		}{Key: key, Size: int64(len(s.objects[key])), LastModified: time.Now().UTC()})
+	}
+
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newTestS3Storage(t *testing.T, standIn *s3StandIn, server *httptest.Server, secretKey string) *S3Storage {
+	storage, err := NewS3Storage(config.S3Config{
+		Endpoint:  server.URL,
+		Bucket:    standIn.bucket,
+		Region:    "us-east-1",
+		AccessKey: "minio",
+		SecretKey: secretKey,
+		Prefix:    "resized/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func TestS3Storage(t *testing.T) {
+	assert := assert.New(t)
+	standIn, server := newS3StandIn(t, "images")
+	storage := newTestS3Storage(t, standIn, server, "minio-secret")
+
+	name := fmt.Sprintf("my photo_%d.png", time.Now().Unix())
+	saved, err := storage.Save(context.Background(), testImage(name))
+	assert.NoError(err)
+	assert.Contains(standIn.objects, "resized/"+name)
+	assert.Equal(hashHex(standIn.objects["resized/"+name]), saved.Checksum)
+
+	info, err := storage.Stat(name)
+	assert.NoError(err)
+	assert.Equal("image/png", info.ContentType)
+	assert.Equal(int64(len(standIn.objects["resized/"+name])), info.Size)
+
+	body, _, err := storage.Open(name)
+	assert.NoError(err)
+	content, _ := io.ReadAll(body)
+	body.Close()
+	assert.Equal(standIn.objects["resized/"+name], content)
+
+	for i := 0; i < 3; i++ {
+		_, err := storage.Save(context.Background(), testImage(fmt.Sprintf("other%d_%d.jpeg", i, time.Now().Unix())))
+		assert.NoError(err)
+	}
+
+	objects, err := storage.List()
+	assert.NoError(err)
+	assert.Len(objects, 4)
+
+	usage, err := storage.Usage()
+	assert.NoError(err)
+	assert.Equal(4, usage.Objects)
+
+	assert.NoError(storage.Delete(name))
+	usage, _ = storage.Usage()
+	assert.Equal(3, usage.Objects)
+	assert.ErrorIs(storage.Delete(name), domain.ErrObjectNotFound)
+	_, _, err = storage.Open(name)
+	assert.ErrorIs(err, domain.ErrObjectNotFound)
+	_, err = storage.Stat(name)
+	assert.ErrorIs(err, domain.ErrObjectNotFound)
+}
+
+func TestS3StorageErrors(t *testing.T) {
+	assert := assert.New(t)
+	standIn, server := newS3StandIn(t, "images")
+
+	storage := newTestS3Storage(t, standIn, server, "wrong-secret")
+	_, err := storage.Save(context.Background(), testImage("photo_1.png"))
+	assert.ErrorContains(err, "SignatureDoesNotMatch")
+	assert.NotErrorIs(err, domain.ErrStorageUnavailable)
+
+	server.Close()
+	storage = newTestS3Storage(t, standIn, server, "")
+	_, err = storage.Save(context.Background(), testImage("photo_1.png"))
+	assert.ErrorIs(err, domain.ErrStorageUnavailable)
+
+	_, err = NewS3Storage(config.S3Config{Endpoint: "localhost:9000", Bucket: "images"})
+	assert.Error(err)
+
+	_, err = NewS3Storage(config.S3Config{Endpoint: server.URL})
+	assert.Error(err)
+}
@@ -0,0 +1,162 @@
+package adapters
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"imageResizerX/codec"
+	"imageResizerX/domain"
+	"imageResizerX/logs"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+var errInvalidName = errors.New("invalid object name")
+
+func encodeImage(w io.Writer, img *domain.ImageResized) error {
+	err := codec.Encode(w, img.Img, img.Encoding, img.Metadata)
+
+	if err != nil {
+		logs.Logger.Error("Failed to performe image encode",
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+// validName rejects names that would escape the storage root or bucket
+// prefix.
+func validName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return errInvalidName
+	}
+	return nil
+}
+
+func contentType(name string) string {
+	return codec.ContentType(strings.TrimPrefix(path.Ext(name), "."))
+}
+
+// unavailable marks err as a failure of the storage backend. The context
+// errors are left as they are.
+func unavailable(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", domain.ErrStorageUnavailable, err)
+}
+
+// backendWriter keeps the first write error of the backend, to tell it
+// apart from the failures of the encoder writing through it.
+type backendWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (b *backendWriter) Write(p []byte) (int, error) {
+	n, err := b.w.Write(p)
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// contextWriter fails the writes once ctx is done, to stop the encoding of
+// an image no longer wanted.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+// checksumWriter counts and hashes what is written through it.
+type checksumWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{w: w, hash: sha256.New()}
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.hash.Write(p[:n])
+	c.size += int64(n)
+	return n, err
+}
+
+func (c *checksumWriter) Checksum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// usageCounter keeps the space taken by a storage that cannot tell it
+// cheaply. It is counted from a full listing the first time it is asked for,
+// then kept up to date by the saves and deletes, and recounted by every
+// listing to correct any drift.
+type usageCounter struct {
+	lock    sync.Mutex
+	usage   domain.StorageUsage
+	counted bool
+}
+
+func (c *usageCounter) get(list func() ([]domain.ObjectInfo, error)) (domain.StorageUsage, error) {
+	c.lock.Lock()
+	counted := c.counted
+	c.lock.Unlock()
+
+	if !counted {
+		if _, err := list(); err != nil {
+			return domain.StorageUsage{}, err
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.usage, nil
+}
+
+func (c *usageCounter) add(size int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.usage.Bytes += size
+	c.usage.Objects++
+}
+
+func (c *usageCounter) remove(size int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.usage.Bytes -= size
+	c.usage.Objects--
+}
+
+func (c *usageCounter) recount(objects []domain.ObjectInfo) {
+	usage := domain.StorageUsage{Objects: len(objects)}
+	for _, object := range objects {
+		usage.Bytes += object.Size
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.usage = usage
+	c.counted = true
+}
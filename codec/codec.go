@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"imageResizerX/domain"
+	"io"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+var encoders = map[string]imaging.Format{
+	"jpeg": imaging.JPEG,
+	"png":  imaging.PNG,
+	"gif":  imaging.GIF,
+	"bmp":  imaging.BMP,
+	"tiff": imaging.TIFF,
+}
+
+var aliases = map[string]string{
+	"jpg": "jpeg",
+	"tif": "tiff",
+}
+
+var pngCompression = map[string]png.CompressionLevel{
+	"":        png.DefaultCompression,
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// NormalizeFormat turns format names such as "JPG" or "tif" into the
+// canonical name used for encoding, file extensions and content types.
+func NormalizeFormat(format string) string {
+	format = strings.ToLower(format)
+	if alias, ok := aliases[format]; ok {
+		return alias
+	}
+	return format
+}
+
+func CanEncode(format string) bool {
+	_, ok := encoders[NormalizeFormat(format)]
+	return ok
+}
+
+// OutputFormat is the format an image is written in when the client did
+// not ask for one: its input format, or PNG for decode-only formats like WebP.
+func OutputFormat(inputFormat string) string {
+	if CanEncode(inputFormat) {
+		return NormalizeFormat(inputFormat)
+	}
+	return "png"
+}
+
+func Validate(opts domain.EncodeOptions) error {
+	if !CanEncode(opts.Format) {
+		return fmt.Errorf("unsupported output format %q", opts.Format)
+	}
+
+	if opts.Quality < 0 || opts.Quality > 100 {
+		return errors.New("quality must be between 1 and 100")
+	}
+
+	if _, ok := pngCompression[opts.Compression]; !ok {
+		return fmt.Errorf("unknown png compression %q", opts.Compression)
+	}
+
+	return nil
+}
+
+// Encode writes img in the requested format. md is embedded when the format
+// can carry it and dropped otherwise.
+func Encode(w io.Writer, img *image.NRGBA, opts domain.EncodeOptions, md domain.Metadata) error {
+	if err := Validate(opts); err != nil {
+		return err
+	}
+
+	format := NormalizeFormat(opts.Format)
+	encodeOpts := []imaging.EncodeOption{}
+
+	switch format {
+	case "jpeg":
+		if opts.Quality > 0 {
+			encodeOpts = append(encodeOpts, imaging.JPEGQuality(opts.Quality))
+		}
+	case "png":
+		encodeOpts = append(encodeOpts, imaging.PNGCompressionLevel(pngCompression[opts.Compression]))
+	}
+
+	if md.IsEmpty() {
+		return imaging.Encode(w, img, encoders[format], encodeOpts...)
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, encoders[format], encodeOpts...); err != nil {
+		return err
+	}
+
+	_, err := w.Write(embedMetadata(buf.Bytes(), format, md))
+	return err
+}
+
+func ContentType(format string) string {
+	return "image/" + NormalizeFormat(format)
+}
+
+func Extension(format string) string {
+	return "." + NormalizeFormat(format)
+}
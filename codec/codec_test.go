@@ -0,0 +1,86 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"imageResizerX/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	assert := assert.New(t)
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+
+	type testCase struct {
+		opts         domain.EncodeOptions
+		expectResult string
+	}
+
+	for _, scenario := range []testCase{
+		{opts: domain.EncodeOptions{Format: "png", Compression: "best"}, expectResult: "png"},
+		{opts: domain.EncodeOptions{Format: "jpg", Quality: 80}, expectResult: "jpeg"},
+		{opts: domain.EncodeOptions{Format: "gif"}, expectResult: "gif"},
+		{opts: domain.EncodeOptions{Format: "bmp"}, expectResult: "bmp"},
+		{opts: domain.EncodeOptions{Format: "tif"}, expectResult: "tiff"},
+	} {
+		t.Run(scenario.opts.Format, func(t *testing.T) {
+			var out bytes.Buffer
+
+			err := Encode(&out, img, scenario.opts, domain.Metadata{})
+			assert.NoError(err)
+
+			config, format, err := image.DecodeConfig(&out)
+			assert.NoError(err)
+			assert.Equal(scenario.expectResult, format)
+			assert.Equal(20, config.Width)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	type testCase struct {
+		name        string
+		opts        domain.EncodeOptions
+		expectError bool
+	}
+
+	for _, scenario := range []testCase{
+		{name: "jpeg quality", opts: domain.EncodeOptions{Format: "jpeg", Quality: 75}},
+		{name: "png compression", opts: domain.EncodeOptions{Format: "png", Compression: "speed"}},
+		{name: "unknown format", opts: domain.EncodeOptions{Format: "xcf"}, expectError: true},
+		{name: "quality too high", opts: domain.EncodeOptions{Format: "jpeg", Quality: 101}, expectError: true},
+		{name: "unknown compression", opts: domain.EncodeOptions{Format: "png", Compression: "max"}, expectError: true},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			err := Validate(scenario.opts)
+
+			if scenario.expectError {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestContentTypeAndExtension(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("image/jpeg", ContentType("jpg"))
+	assert.Equal("image/tiff", ContentType("TIF"))
+	assert.Equal(".jpeg", Extension("jpg"))
+	assert.Equal(".png", Extension("png"))
+}
+
+func TestOutputFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("jpeg", OutputFormat("jpeg"))
+	assert.Equal("png", OutputFormat("png"))
+	assert.Equal("png", OutputFormat("webp"))
+}
@@ -0,0 +1,152 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	tagOrientation = 0x0112
+	tagCopyright   = 0x8298
+
+	typeASCII = 2
+	typeShort = 3
+)
+
+var errInvalidExif = errors.New("invalid exif data")
+
+// exifIFD0 gives access to the first image directory of a TIFF structure,
+// which holds the tags we care about.
+type exifIFD0 struct {
+	data   []byte
+	order  binary.ByteOrder
+	offset int
+	count  int
+}
+
+func parseExif(data []byte) (*exifIFD0, error) {
+	if len(data) < 8 {
+		return nil, errInvalidExif
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, errInvalidExif
+	}
+
+	offset := int(order.Uint32(data[4:8]))
+	if offset < 8 || offset+2 > len(data) {
+		return nil, errInvalidExif
+	}
+
+	count := int(order.Uint16(data[offset:]))
+	if offset+2+count*12 > len(data) {
+		return nil, errInvalidExif
+	}
+
+	return &exifIFD0{data: data, order: order, offset: offset, count: count}, nil
+}
+
+// entry returns the position of the 12 bytes entry of tag, or -1.
+func (ifd *exifIFD0) entry(tag uint16) int {
+	for i := 0; i < ifd.count; i++ {
+		pos := ifd.offset + 2 + i*12
+		if ifd.order.Uint16(ifd.data[pos:]) == tag {
+			return pos
+		}
+	}
+	return -1
+}
+
+func (ifd *exifIFD0) ascii(tag uint16) string {
+	pos := ifd.entry(tag)
+	if pos < 0 || ifd.order.Uint16(ifd.data[pos+2:]) != typeASCII {
+		return ""
+	}
+
+	size := int(ifd.order.Uint32(ifd.data[pos+4:]))
+	value := ifd.data[pos+8 : pos+12]
+
+	if size > 4 {
+		start := int(ifd.order.Uint32(ifd.data[pos+8:]))
+		if start < 0 || start+size > len(ifd.data) {
+			return ""
+		}
+		value = ifd.data[start : start+size]
+	} else {
+		value = value[:size]
+	}
+
+	return string(bytes.TrimRight(value, "\x00"))
+}
+
+// Orientation returns the EXIF orientation, from 1 for upright to 8, or 1
+// when the exif data holds none.
+func Orientation(data []byte) int {
+	ifd, err := parseExif(data)
+	if err != nil {
+		return 1
+	}
+
+	pos := ifd.entry(tagOrientation)
+	if pos < 0 || ifd.order.Uint16(data[pos+2:]) != typeShort {
+		return 1
+	}
+
+	orientation := int(ifd.order.Uint16(data[pos+8:]))
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// withOrientation returns a copy of the exif data with the orientation tag
+// set to value, used once the pixels were rotated upright.
+func withOrientation(data []byte, value uint16) []byte {
+	ifd, err := parseExif(data)
+	if err != nil {
+		return nil
+	}
+
+	pos := ifd.entry(tagOrientation)
+	if pos < 0 || ifd.order.Uint16(data[pos+2:]) != typeShort {
+		return data
+	}
+
+	out := append([]byte(nil), data...)
+	ifd.order.PutUint16(out[pos+8:], value)
+	return out
+}
+
+// copyrightExif builds a minimal exif structure holding only the copyright
+// notice.
+func copyrightExif(copyright string) []byte {
+	if copyright == "" {
+		return nil
+	}
+
+	value := append([]byte(copyright), 0)
+	order := binary.LittleEndian
+
+	out := make([]byte, 26, 26+len(value))
+	copy(out, "II*\x00")
+	order.PutUint32(out[4:], 8)
+	order.PutUint16(out[8:], 1)
+	order.PutUint16(out[10:], tagCopyright)
+	order.PutUint16(out[12:], typeASCII)
+	order.PutUint32(out[14:], uint32(len(value)))
+
+	if len(value) <= 4 {
+		copy(out[18:22], value)
+		return out
+	}
+
+	order.PutUint32(out[18:], 26)
+	return append(out, value...)
+}
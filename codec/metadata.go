@@ -0,0 +1,295 @@
+package codec
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"imageResizerX/domain"
+	"io"
+	"sort"
+)
+
+const (
+	exifHeader = "Exif\x00\x00"
+	iccHeader  = "ICC_PROFILE\x00"
+
+	// maxJPEGSegment is the payload limit of a JPEG APPn segment.
+	maxJPEGSegment = 65533
+
+	// maxICCProfile bounds the decompressed size of a PNG iCCP profile.
+	maxICCProfile = 4 << 20
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func ParseMetadataPolicy(policy string) (domain.MetadataPolicy, error) {
+	switch domain.MetadataPolicy(policy) {
+	case "", domain.MetadataStrip:
+		return domain.MetadataStrip, nil
+	case domain.MetadataCopyright, domain.MetadataAll:
+		return domain.MetadataPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown metadata policy %q", policy)
+	}
+}
+
+// ExtractMetadata reads the EXIF and ICC profile of a JPEG, PNG or WebP
+// file. Other formats and malformed files give empty metadata.
+func ExtractMetadata(data []byte) domain.Metadata {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return extractJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return extractPNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return extractWebP(data)
+	default:
+		return domain.Metadata{}
+	}
+}
+
+// FilterMetadata keeps what policy allows. When the image was auto-oriented
+// the kept EXIF says so, otherwise viewers would rotate it a second time.
+func FilterMetadata(md domain.Metadata, policy domain.MetadataPolicy, oriented bool) domain.Metadata {
+	switch policy {
+	case domain.MetadataAll:
+		if oriented && len(md.Exif) > 0 {
+			md.Exif = withOrientation(md.Exif, 1)
+		}
+		return md
+	case domain.MetadataCopyright:
+		kept := domain.Metadata{ICC: md.ICC}
+		if ifd, err := parseExif(md.Exif); err == nil {
+			kept.Exif = copyrightExif(ifd.ascii(tagCopyright))
+		}
+		return kept
+	default:
+		return domain.Metadata{}
+	}
+}
+
+func extractJPEG(data []byte) domain.Metadata {
+	md := domain.Metadata{}
+	iccChunks := map[int][]byte{}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			break
+		}
+
+		marker := data[pos+1]
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			pos += 2
+			continue
+		}
+
+		// start of scan, no more metadata past this point
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if size < 2 || pos+2+size > len(data) {
+			break
+		}
+
+		segment := data[pos+4 : pos+2+size]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte(exifHeader)) && md.Exif == nil:
+			md.Exif = append([]byte(nil), segment[len(exifHeader):]...)
+		case marker == 0xE2 && bytes.HasPrefix(segment, []byte(iccHeader)) && len(segment) > len(iccHeader)+2:
+			seq := int(segment[len(iccHeader)])
+			iccChunks[seq] = segment[len(iccHeader)+2:]
+		}
+
+		pos += 2 + size
+	}
+
+	if len(iccChunks) > 0 {
+		seqs := make([]int, 0, len(iccChunks))
+		for seq := range iccChunks {
+			seqs = append(seqs, seq)
+		}
+		sort.Ints(seqs)
+
+		for _, seq := range seqs {
+			md.ICC = append(md.ICC, iccChunks[seq]...)
+		}
+	}
+
+	return md
+}
+
+func extractPNG(data []byte) domain.Metadata {
+	md := domain.Metadata{}
+
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		if size < 0 || pos+12+size > len(data) {
+			break
+		}
+
+		kind := string(data[pos+4 : pos+8])
+		chunk := data[pos+8 : pos+8+size]
+
+		switch kind {
+		case "eXIf":
+			md.Exif = append([]byte(nil), chunk...)
+		case "iCCP":
+			md.ICC = readICCP(chunk)
+		case "IEND":
+			return md
+		}
+
+		pos += 12 + size
+	}
+
+	return md
+}
+
+// readICCP decompresses the profile of an iCCP chunk: a profile name, a
+// null separator, the compression method and the zlib stream. Profiles
+// larger than maxICCProfile are dropped.
+func readICCP(chunk []byte) []byte {
+	sep := bytes.IndexByte(chunk, 0)
+	if sep < 0 || sep+2 > len(chunk) {
+		return nil
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(chunk[sep+2:]))
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+
+	profile, err := io.ReadAll(io.LimitReader(reader, maxICCProfile+1))
+	if err != nil || len(profile) > maxICCProfile {
+		return nil
+	}
+
+	return profile
+}
+
+func extractWebP(data []byte) domain.Metadata {
+	md := domain.Metadata{}
+
+	pos := 12
+	for pos+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if size < 0 || pos+8+size > len(data) {
+			break
+		}
+
+		chunk := data[pos+8 : pos+8+size]
+
+		switch string(data[pos : pos+4]) {
+		case "EXIF":
+			md.Exif = append([]byte(nil), bytes.TrimPrefix(chunk, []byte(exifHeader))...)
+		case "ICCP":
+			md.ICC = append([]byte(nil), chunk...)
+		}
+
+		pos += 8 + size + size%2
+	}
+
+	return md
+}
+
+// embedMetadata writes md into an encoded image. Only JPEG and PNG can
+// carry it, other formats are returned unchanged.
+func embedMetadata(encoded []byte, format string, md domain.Metadata) []byte {
+	switch format {
+	case "jpeg":
+		return embedJPEG(encoded, md)
+	case "png":
+		return embedPNG(encoded, md)
+	default:
+		return encoded
+	}
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func embedJPEG(encoded []byte, md domain.Metadata) []byte {
+	if len(encoded) < 2 {
+		return encoded
+	}
+
+	var segments []byte
+
+	if len(md.Exif) > 0 && len(exifHeader)+len(md.Exif) <= maxJPEGSegment {
+		segments = append(segments, jpegSegment(0xE1, append([]byte(exifHeader), md.Exif...))...)
+	}
+
+	if len(md.ICC) > 0 {
+		chunkSize := maxJPEGSegment - len(iccHeader) - 2
+		count := (len(md.ICC) + chunkSize - 1) / chunkSize
+
+		if count <= 255 {
+			for i := 0; i < count; i++ {
+				end := (i + 1) * chunkSize
+				if end > len(md.ICC) {
+					end = len(md.ICC)
+				}
+
+				payload := append([]byte(iccHeader), byte(i+1), byte(count))
+				payload = append(payload, md.ICC[i*chunkSize:end]...)
+				segments = append(segments, jpegSegment(0xE2, payload)...)
+			}
+		}
+	}
+
+	out := make([]byte, 0, len(encoded)+len(segments))
+	out = append(out, encoded[:2]...)
+	out = append(out, segments...)
+	return append(out, encoded[2:]...)
+}
+
+func pngChunk(kind string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, data...)
+
+	crc := crc32.NewIEEE()
+	crc.Write(chunk[4:])
+	return binary.BigEndian.AppendUint32(chunk, crc.Sum32())
+}
+
+func embedPNG(encoded []byte, md domain.Metadata) []byte {
+	// the IHDR chunk always comes first and is 25 bytes long
+	ihdrEnd := len(pngSignature) + 25
+	if len(encoded) < ihdrEnd {
+		return encoded
+	}
+
+	var chunks []byte
+
+	if len(md.ICC) > 0 {
+		var compressed bytes.Buffer
+		writer := zlib.NewWriter(&compressed)
+		writer.Write(md.ICC)
+		writer.Close()
+
+		data := append([]byte("ICC profile\x00\x00"), compressed.Bytes()...)
+		chunks = append(chunks, pngChunk("iCCP", data)...)
+	}
+
+	if len(md.Exif) > 0 {
+		chunks = append(chunks, pngChunk("eXIf", md.Exif)...)
+	}
+
+	out := make([]byte, 0, len(encoded)+len(chunks))
+	out = append(out, encoded[:ihdrEnd]...)
+	out = append(out, chunks...)
+	return append(out, encoded[ihdrEnd:]...)
+}
@@ -0,0 +1,124 @@
+package codec
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"imageResizerX/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testExif builds a little endian exif structure with an orientation, a
+// copyright notice and a GPS IFD pointer.
+func testExif(orientation uint16, copyright string) []byte {
+	order := binary.LittleEndian
+	value := append([]byte(copyright), 0)
+
+	out := make([]byte, 50, 50+len(value))
+	copy(out, "II*\x00")
+	order.PutUint32(out[4:], 8)
+	order.PutUint16(out[8:], 3)
+
+	order.PutUint16(out[10:], tagOrientation)
+	order.PutUint16(out[12:], typeShort)
+	order.PutUint32(out[14:], 1)
+	order.PutUint16(out[18:], orientation)
+
+	order.PutUint16(out[22:], tagCopyright)
+	order.PutUint16(out[24:], typeASCII)
+	order.PutUint32(out[26:], uint32(len(value)))
+	order.PutUint32(out[30:], 50)
+
+	order.PutUint16(out[34:], 0x8825)
+	order.PutUint16(out[36:], 4)
+	order.PutUint32(out[38:], 1)
+
+	return append(out, value...)
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	md := domain.Metadata{Exif: testExif(6, "ACME"), ICC: bytes.Repeat([]byte("icc"), 30000)}
+
+	type testCase struct {
+		format       string
+		expectResult domain.Metadata
+	}
+
+	for _, scenario := range []testCase{
+		{format: "jpeg", expectResult: md},
+		{format: "png", expectResult: md},
+		{format: "gif", expectResult: domain.Metadata{}},
+	} {
+		t.Run(scenario.format, func(t *testing.T) {
+			var out bytes.Buffer
+
+			err := Encode(&out, img, domain.EncodeOptions{Format: scenario.format}, md)
+			assert.NoError(err)
+			assert.Equal(scenario.expectResult, ExtractMetadata(out.Bytes()))
+
+			config, _, err := image.DecodeConfig(bytes.NewReader(out.Bytes()))
+			assert.NoError(err)
+			assert.Equal(20, config.Width)
+		})
+	}
+}
+
+func TestReadICCP(t *testing.T) {
+	assert := assert.New(t)
+
+	chunk := func(size int) []byte {
+		var out bytes.Buffer
+		out.WriteString("icc\x00\x00")
+		writer := zlib.NewWriter(&out)
+		writer.Write(make([]byte, size))
+		writer.Close()
+		return out.Bytes()
+	}
+
+	assert.Len(readICCP(chunk(maxICCProfile)), maxICCProfile)
+	assert.Nil(readICCP(chunk(maxICCProfile + 1)))
+	assert.Nil(readICCP(chunk(64 << 20)))
+}
+
+func TestFilterMetadata(t *testing.T) {
+	assert := assert.New(t)
+	md := domain.Metadata{Exif: testExif(6, "ACME"), ICC: []byte("icc")}
+
+	assert.True(FilterMetadata(md, domain.MetadataStrip, true).IsEmpty())
+
+	kept := FilterMetadata(md, domain.MetadataCopyright, true)
+	assert.Equal(md.ICC, kept.ICC)
+	ifd, err := parseExif(kept.Exif)
+	assert.NoError(err)
+	assert.Equal("ACME", ifd.ascii(tagCopyright))
+	assert.Equal(-1, ifd.entry(0x8825))
+
+	kept = FilterMetadata(md, domain.MetadataAll, true)
+	ifd, err = parseExif(kept.Exif)
+	assert.NoError(err)
+	assert.Equal(uint16(1), ifd.order.Uint16(kept.Exif[ifd.entry(tagOrientation)+8:]))
+	assert.NotEqual(-1, ifd.entry(0x8825))
+
+	kept = FilterMetadata(md, domain.MetadataAll, false)
+	assert.Equal(md, kept)
+}
+
+func TestParseMetadataPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	policy, err := ParseMetadataPolicy("")
+	assert.NoError(err)
+	assert.Equal(domain.MetadataStrip, policy)
+
+	policy, err = ParseMetadataPolicy("copyright")
+	assert.NoError(err)
+	assert.Equal(domain.MetadataCopyright, policy)
+
+	_, err = ParseMetadataPolicy("gps")
+	assert.Error(err)
+}
@@ -0,0 +1,328 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	PresetsFile string
+	// Storage selects where resized images are kept, "local", "memory" or
+	// "s3".
+	Storage     string
+	StorageRoot string
+	// MemoryBudget is the most bytes the "memory" storage holds.
+	MemoryBudget int64
+	// StorageQuota is the most bytes stored before uploads are refused,
+	// zero for no limit.
+	StorageQuota int64
+	S3           S3Config
+	// RecordStore selects where the image records are kept, "json" for a
+	// sidecar file per image, "bolt" for an embedded database or "memory",
+	// the default of the memory storage, to keep them in memory only.
+	RecordStore string
+	// RecordPath is the directory of the "json" sidecars or the file of the
+	// "bolt" database.
+	RecordPath string
+	// APITokens maps the accepted bearer tokens to the owner they
+	// authenticate.
+	APITokens map[string]string
+	// AdminToken is the bearer token of the admin endpoints, which are
+	// disabled without it.
+	AdminToken string
+	// ImageTTL is how long stored images are kept when the upload does not
+	// say, MaxImageTTL the longest an upload may ask for.
+	ImageTTL    time.Duration
+	MaxImageTTL time.Duration
+	// SweepInterval is how often expired images are deleted.
+	SweepInterval time.Duration
+	// Workers is the number of images processed at once, QueueSize the
+	// number of jobs waiting for a worker before uploads are refused.
+	Workers   int
+	QueueSize int
+	// ClientMaxWorkers is the most workers the jobs of one client take at
+	// once, ClientQueueSize the most jobs of one client waiting for a
+	// worker, PriorityWeights and ClientWeights the share of the workers
+	// given to a priority and to a client when jobs are waiting.
+	ClientMaxWorkers int
+	ClientQueueSize  int
+	PriorityWeights  map[string]int
+	ClientWeights    map[string]int
+	// JobTimeout is how long each attempt of a job may take once a worker
+	// started it, a retried job is given it again.
+	JobTimeout time.Duration
+	// ShutdownGrace is how long the jobs left are given to finish once the
+	// server is asked to stop.
+	ShutdownGrace time.Duration
+	// JobMaxAttempts is how many times a job failing with a transient error
+	// is run, the wait between two doubling from RetryBackoff up to
+	// RetryMaxBackoff.
+	JobMaxAttempts  int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	// QueueDir is where the queued jobs are written down to be run again
+	// after a restart, they are only kept in memory when empty.
+	QueueDir string
+}
+
+// S3Config locates a bucket of an S3 compatible object store.
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to every key, so the bucket can be shared.
+	Prefix string
+}
+
+func FromEnv() (Config, error) {
+	memoryBudget, err := getEnvInt64("MEMORY_BUDGET", 256<<20)
+	if err != nil {
+		return Config{}, err
+	}
+
+	storageQuota, err := getEnvInt64("STORAGE_QUOTA", 0)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if storageQuota < 0 {
+		return Config{}, fmt.Errorf("STORAGE_QUOTA must not be negative, got %d", storageQuota)
+	}
+
+	apiTokens, err := parseTokens(getEnv("API_TOKENS", ""))
+	if err != nil {
+		return Config{}, err
+	}
+
+	imageTTL, err := getEnvDuration("IMAGE_TTL", time.Minute*5)
+	if err != nil {
+		return Config{}, err
+	}
+
+	maxImageTTL, err := getEnvDuration("MAX_IMAGE_TTL", time.Hour*24)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if imageTTL > maxImageTTL {
+		return Config{}, fmt.Errorf("IMAGE_TTL %s is longer than MAX_IMAGE_TTL %s", imageTTL, maxImageTTL)
+	}
+
+	sweepInterval, err := getEnvDuration("SWEEP_INTERVAL", time.Minute)
+	if err != nil {
+		return Config{}, err
+	}
+
+	workers, err := getEnvInt64("WORKERS", 5)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if workers <= 0 {
+		return Config{}, fmt.Errorf("WORKERS must be positive, got %d", workers)
+	}
+
+	queueSize, err := getEnvInt64("QUEUE_SIZE", 100)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if queueSize < 0 {
+		return Config{}, fmt.Errorf("QUEUE_SIZE must not be negative, got %d", queueSize)
+	}
+
+	clientMaxWorkers, err := getEnvInt64("CLIENT_MAX_WORKERS", max(workers-1, 1))
+	if err != nil {
+		return Config{}, err
+	}
+
+	if clientMaxWorkers < 0 {
+		return Config{}, fmt.Errorf("CLIENT_MAX_WORKERS must not be negative, got %d", clientMaxWorkers)
+	}
+
+	clientQueueSize, err := getEnvInt64("CLIENT_QUEUE_SIZE", max(queueSize/4, 1))
+	if err != nil {
+		return Config{}, err
+	}
+
+	if clientQueueSize < 0 {
+		return Config{}, fmt.Errorf("CLIENT_QUEUE_SIZE must not be negative, got %d", clientQueueSize)
+	}
+
+	priorityWeights, err := parseWeights("PRIORITY_WEIGHTS", getEnv("PRIORITY_WEIGHTS", "interactive:4,batch:1"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	clientWeights, err := parseWeights("CLIENT_WEIGHTS", getEnv("CLIENT_WEIGHTS", ""))
+	if err != nil {
+		return Config{}, err
+	}
+
+	jobTimeout, err := getEnvDuration("JOB_TIMEOUT", time.Minute*2)
+	if err != nil {
+		return Config{}, err
+	}
+
+	shutdownGrace, err := getEnvDuration("SHUTDOWN_GRACE", time.Second*30)
+	if err != nil {
+		return Config{}, err
+	}
+
+	jobMaxAttempts, err := getEnvInt64("JOB_MAX_ATTEMPTS", 3)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if jobMaxAttempts <= 0 {
+		return Config{}, fmt.Errorf("JOB_MAX_ATTEMPTS must be positive, got %d", jobMaxAttempts)
+	}
+
+	retryBackoff, err := getEnvDuration("RETRY_BACKOFF", time.Second)
+	if err != nil {
+		return Config{}, err
+	}
+
+	retryMaxBackoff, err := getEnvDuration("RETRY_MAX_BACKOFF", time.Second*30)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if retryBackoff > retryMaxBackoff {
+		return Config{}, fmt.Errorf("RETRY_BACKOFF %s is longer than RETRY_MAX_BACKOFF %s", retryBackoff, retryMaxBackoff)
+	}
+
+	storage := getEnv("STORAGE", "local")
+
+	// the memory storage runs without a writable filesystem
+	defaultRecordStore := "json"
+	if storage == "memory" {
+		defaultRecordStore = "memory"
+	}
+
+	recordStore := getEnv("RECORD_STORE", defaultRecordStore)
+	recordPath := "records"
+	if recordStore == "bolt" {
+		recordPath = "records.db"
+	}
+
+	return Config{
+		PresetsFile:  getEnv("PRESETS_FILE", "presets.json"),
+		Storage:      storage,
+		StorageRoot:  getEnv("STORAGE_ROOT", "uploads"),
+		MemoryBudget: memoryBudget,
+		StorageQuota: storageQuota,
+		S3: S3Config{
+			Endpoint:  getEnv("S3_ENDPOINT", ""),
+			Bucket:    getEnv("S3_BUCKET", ""),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			AccessKey: getEnv("S3_ACCESS_KEY", ""),
+			SecretKey: getEnv("S3_SECRET_KEY", ""),
+			Prefix:    getEnv("S3_PREFIX", ""),
+		},
+		RecordStore:      recordStore,
+		RecordPath:       getEnv("RECORD_PATH", recordPath),
+		APITokens:        apiTokens,
+		AdminToken:       getEnv("ADMIN_TOKEN", ""),
+		ImageTTL:         imageTTL,
+		MaxImageTTL:      maxImageTTL,
+		SweepInterval:    sweepInterval,
+		Workers:          int(workers),
+		QueueSize:        int(queueSize),
+		ClientMaxWorkers: int(clientMaxWorkers),
+		ClientQueueSize:  int(clientQueueSize),
+		PriorityWeights:  priorityWeights,
+		ClientWeights:    clientWeights,
+		JobTimeout:       jobTimeout,
+		ShutdownGrace:    shutdownGrace,
+		JobMaxAttempts:   int(jobMaxAttempts),
+		RetryBackoff:     retryBackoff,
+		RetryMaxBackoff:  retryMaxBackoff,
+		QueueDir:         getEnv("QUEUE_DIR", ""),
+	}, nil
+}
+
+// parseTokens reads a comma separated list of token:owner pairs.
+func parseTokens(value string) (map[string]string, error) {
+	tokens := map[string]string{}
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		token, owner, ok := strings.Cut(pair, ":")
+		if !ok || token == "" || owner == "" {
+			return nil, fmt.Errorf("API_TOKENS entries must look like token:owner, got %q", pair)
+		}
+
+		tokens[token] = owner
+	}
+
+	return tokens, nil
+}
+
+// parseWeights reads a comma separated list of name:weight pairs, the
+// weights being positive integers.
+func parseWeights(key string, value string) (map[string]int, error) {
+	weights := map[string]int{}
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, raw, ok := strings.Cut(pair, ":")
+		weight, err := strconv.Atoi(raw)
+		if !ok || name == "" || err != nil || weight <= 0 {
+			return nil, fmt.Errorf("%s entries must look like name:weight with a positive weight, got %q", key, pair)
+		}
+
+		weights[name] = weight
+	}
+
+	return weights, nil
+}
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt64(key string, fallback int64) (int64, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback, nil
+	}
+
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer, got %q", key, value)
+	}
+
+	return number, nil
+}
+
+// getEnvDuration reads a positive duration like "90s" or "12h".
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration like 90s or 12h, got %q", key, value)
+	}
+
+	return duration, nil
+}
@@ -2,38 +2,44 @@ package domain
 
 import (
 	"image"
-	"regexp"
-	"strconv"
-	"strings"
 	"time"
 )
 
 type ImageResized struct {
-	Img    *image.NRGBA
-	Name   string
-	Format string
+	Img      *image.NRGBA
+	Name     string
+	Encoding EncodeOptions
+	Metadata Metadata
 }
 
-type MemoryImg struct {
-	FilePath string
-}
+// ImageLifetime is how long a resized image is kept before being swept,
+// unless its upload asked otherwise.
+const ImageLifetime = time.Minute * 5
 
-func (m *MemoryImg) IsValid() bool {
-	lifeTime := int64(60 * 5)
-	return m.CreatedAtUnix()+lifeTime >= time.Now().Unix()
+// EncodeOptions describes how a resized image is written out. Quality only
+// applies to JPEG and Compression only to PNG.
+type EncodeOptions struct {
+	Format      string
+	Quality     int
+	Compression string
 }
 
-func (m *MemoryImg) CreatedAtUnix() int64 {
-	rgx := regexp.MustCompile(`_(\d+)\.`)
+// MetadataPolicy tells which metadata of the upload is written into its
+// outputs.
+type MetadataPolicy string
 
-	match := rgx.FindStringSubmatch(m.FilePath)
-	unixDateAsString := match[1]
+const (
+	MetadataStrip     MetadataPolicy = "strip"
+	MetadataCopyright MetadataPolicy = "copyright"
+	MetadataAll       MetadataPolicy = "all"
+)
 
-	createdAt, _ := strconv.ParseInt(unixDateAsString, 10, 64)
-	return createdAt
+// Metadata is the raw EXIF (a TIFF structure) and ICC profile of an image.
+type Metadata struct {
+	Exif []byte
+	ICC  []byte
 }
 
-func (m *MemoryImg) Format() string {
-	parts := strings.Split(m.FilePath, ".")
-	return parts[len(parts)-1]
+func (m Metadata) IsEmpty() bool {
+	return len(m.Exif) == 0 && len(m.ICC) == 0
 }
@@ -0,0 +1,60 @@
+package domain
+
+import "time"
+
+type JobState string
+
+const (
+	JobQueued     JobState = "queued"
+	JobProcessing JobState = "processing"
+	JobComplete   JobState = "complete"
+	JobFailed     JobState = "failed"
+	JobCancelled  JobState = "cancelled"
+	JobExpired    JobState = "expired"
+)
+
+type Job struct {
+	ID          string       `json:"id"`
+	State       JobState     `json:"state"`
+	CreatedAt   time.Time    `json:"created_at"`
+	StartedAt   *time.Time   `json:"started_at,omitempty"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	DownloadUrl string       `json:"download_url,omitempty"`
+	Variants    []JobVariant `json:"variants,omitempty"`
+	// Attempts counts the times the job was started, more than one once it
+	// was retried.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// JobVariant describes one output of a job with the parameters needed to
+// reproduce it, and its download once the job is complete.
+type JobVariant struct {
+	Name        string `json:"name"`
+	Filter      string `json:"filter"`
+	DownloadUrl string `json:"download_url,omitempty"`
+}
+
+// Expire moves a complete job whose output is gone to the expired state.
+func (j *Job) Expire(now time.Time) {
+	if j.State != JobComplete || j.ExpiresAt == nil || now.Before(*j.ExpiresAt) {
+		return
+	}
+
+	j.State = JobExpired
+	j.DownloadUrl = ""
+	for i := range j.Variants {
+		j.Variants[i].DownloadUrl = ""
+	}
+}
+
+// DeadLetter is a job that kept failing until it ran out of attempts.
+type DeadLetter struct {
+	JobID    string    `json:"job_id"`
+	Client   string    `json:"client"`
+	Filename string    `json:"filename"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
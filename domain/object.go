@@ -0,0 +1,34 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrObjectNotFound is returned by storages asked for a name they do not
+// hold.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrStorageUnavailable is matched by the failures of the storage backend
+// itself, like a full disk or an unreachable bucket, rather than of the
+// image being saved.
+var ErrStorageUnavailable = errors.New("storage unavailable")
+
+// ObjectInfo describes a stored image. Checksum, the hex sha256 of the
+// content, is only known right after the image was saved.
+type ObjectInfo struct {
+	Name        string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	Checksum    string
+}
+
+// StorageUsage is the space taken by the images of a storage. Budget is the
+// most bytes the storage holds by itself, zero when it has no limit of its
+// own.
+type StorageUsage struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int   `json:"objects"`
+	Budget  int64 `json:"budget,omitempty"`
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrQueuedJobNotFound is returned by job journals asked for a job they do
+// not hold.
+var ErrQueuedJobNotFound = errors.New("queued job not found")
+
+type QueuedJobState string
+
+const (
+	QueuedJobWaiting QueuedJobState = "queued"
+	QueuedJobRunning QueuedJobState = "running"
+	// QueuedJobRetryable is a job that was running when the server stopped,
+	// to be run again.
+	QueuedJobRetryable QueuedJobState = "retryable"
+)
+
+// QueuedJob is a job written down before it is queued, with everything
+// needed to run it again after a restart: the uploaded bytes in Source and
+// the parameters of the job, opaque to the journal, in Params.
+type QueuedJob struct {
+	ID       string          `json:"id"`
+	Client   string          `json:"client"`
+	Priority string          `json:"priority"`
+	Params   json.RawMessage `json:"params"`
+	Source   []byte          `json:"-"`
+	State    QueuedJobState  `json:"state"`
+	// Interruptions counts the restarts the job was running at.
+	Interruptions int       `json:"interruptions,omitempty"`
+	QueuedAt      time.Time `json:"queued_at"`
+}
@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrRecordNotFound is returned by record stores asked for an image they
+// hold no record of.
+var ErrRecordNotFound = errors.New("record not found")
+
+// ImageRecord is the metadata kept for every stored image. ID is the name
+// the image is stored under and ContentKey identifies the upload and
+// parameters it was produced from.
+type ImageRecord struct {
+	ID               string          `json:"id"`
+	ContentKey       string          `json:"content_key"`
+	Owner            string          `json:"owner,omitempty"`
+	OriginalFilename string          `json:"original_filename"`
+	DownloadFilename string          `json:"download_filename"`
+	Variant          string          `json:"variant"`
+	SourceWidth      int             `json:"source_width"`
+	SourceHeight     int             `json:"source_height"`
+	Width            int             `json:"width"`
+	Height           int             `json:"height"`
+	Format           string          `json:"format"`
+	Size             int64           `json:"size"`
+	Checksum         string          `json:"checksum"`
+	Operations       json.RawMessage `json:"operations,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	// ExpiresAt is nil for images kept forever.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (r ImageRecord) IsExpired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
+}
@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageRecordIsExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	createdAt := time.Date(2023, 11, 1, 10, 0, 0, 0, time.UTC)
+	expiresAt := createdAt.Add(ImageLifetime)
+	record := ImageRecord{ID: "3f2b9c1e.png", CreatedAt: createdAt, ExpiresAt: &expiresAt}
+
+	assert.False(record.IsExpired(createdAt))
+	assert.False(record.IsExpired(createdAt.Add(ImageLifetime - time.Second)))
+	assert.True(record.IsExpired(createdAt.Add(ImageLifetime)))
+
+	record.ExpiresAt = nil
+	assert.False(record.IsExpired(createdAt.Add(time.Hour * 24 * 365 * 100)))
+}
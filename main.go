@@ -1,22 +1,90 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"imageResizerX/config"
+	"imageResizerX/logs"
 	"imageResizerX/middleware"
 	"imageResizerX/ports"
 	"imageResizerX/server"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
 )
 
 func main() {
-	httpApp := ports.NewHttpApp()
+	cfg, err := config.FromEnv()
+	if err != nil {
+		logs.Logger.Fatal("Invalid configuration", zap.Error(err))
+	}
+
+	httpApp, err := ports.NewHttpApp(cfg)
+	if err != nil {
+		logs.Logger.Fatal("Failed to create http app", zap.Error(err))
+	}
+
 	httpServer := server.NewHttpServer()
 
-	httpServer.Post("/api/v1/upload", middleware.ImageFmtValidatorMiddleware(httpApp.UploadHandler))
+	httpServer.Post("/api/v1/upload", middleware.AuthMiddleware(cfg.APITokens, middleware.ImageFmtValidatorMiddleware(httpApp.UploadHandler)))
+	httpServer.Post("/api/v1/resize", middleware.AuthMiddleware(cfg.APITokens, middleware.ImageFmtValidatorMiddleware(httpApp.ResizeHandler)))
 	httpServer.Get("/", ports.Home)
 	httpServer.Get("/ws", httpApp.WebsocketHandler)
 	httpServer.Get("/api/v1/download/", httpApp.DownloadHandler)
+	httpServer.Get("/api/v1/jobs/", httpApp.JobHandler)
+	httpServer.Delete("/api/v1/jobs/", httpApp.CancelJobHandler)
+	httpServer.Get("/api/v1/presets", httpApp.PresetsHandler)
+	httpServer.Get("/api/v1/admin/usage", middleware.AdminMiddleware(cfg.AdminToken, httpApp.UsageHandler))
+	httpServer.Get("/api/v1/admin/queue", middleware.AdminMiddleware(cfg.AdminToken, httpApp.QueueHandler))
+	httpServer.Get("/api/v1/admin/dead-letters", middleware.AdminMiddleware(cfg.AdminToken, httpApp.DeadLettersHandler))
+
+	srv := &http.Server{Addr: ":8080", Handler: httpServer}
+
+	go func() {
+		fmt.Println("Server is running on :8080...")
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logs.Logger.Fatal("Failed to serve", zap.Error(err))
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	// a second signal kills the server right away
+	stop()
+
+	os.Exit(shutdown(srv, httpApp, cfg.ShutdownGrace))
+}
+
+// shutdown stops accepting requests, then gives the jobs left grace to
+// finish. It returns the exit status, 1 when they did not.
+func shutdown(srv *http.Server, app interface{ Shutdown(context.Context) error }, grace time.Duration) int {
+	logs.Logger.Info("Shutting down, draining jobs", zap.Duration("grace", grace))
+	defer logs.Logger.Sync()
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	status := 0
+
+	// WebSocket connections are not waited for, they are closed once the
+	// jobs they follow are done
+	if err := srv.Shutdown(ctx); err != nil {
+		logs.Logger.Error("Requests not finished in time", zap.Error(err))
+		status = 1
+	}
+
+	if err := app.Shutdown(ctx); err != nil {
+		logs.Logger.Error("Jobs not drained in time", zap.Error(err))
+		return 1
+	}
 
-	fmt.Println("Server is running on :8080...")
-	http.ListenAndServe(":8080", httpServer)
+	if status == 0 {
+		logs.Logger.Info("Jobs drained")
+	}
+	return status
 }
@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"net/http"
 	"strings"
 )
@@ -9,10 +11,17 @@ import (
 var validImageInputs = []string{
 	"image/png",
 	"image/jpeg",
+	"image/webp",
 }
 
-func matchImageFmt(format string) bool {
-	for _, f := range validImageInputs {
+// unsupportedImageInputs are recognised image formats that cannot be decoded
+// without cgo yet.
+var unsupportedImageInputs = []string{
+	"image/avif",
+}
+
+func matchImageFmt(format string, formats []string) bool {
+	for _, f := range formats {
 		if format == f {
 			return true
 		}
@@ -20,6 +29,19 @@ func matchImageFmt(format string) bool {
 	return false
 }
 
+// detectContentType extends http.DetectContentType with the ISO-BMFF based
+// formats it does not know about.
+func detectContentType(buffer []byte) string {
+	if len(buffer) >= 12 && bytes.Equal(buffer[4:8], []byte("ftyp")) {
+		switch string(buffer[8:12]) {
+		case "avif", "avis":
+			return "image/avif"
+		}
+	}
+
+	return http.DetectContentType(buffer)
+}
+
 type ImageFmt string
 
 const ImgFmt ImageFmt = "imgFmt"
@@ -35,16 +57,23 @@ func ImageFmtValidatorMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		defer file.Close()
 
 		buffer := make([]byte, 512)
-		_, err = file.Read(buffer)
+		n, err := file.Read(buffer)
 
 		if err != nil {
 			http.Error(w, "Failed to read file content.", http.StatusInternalServerError)
+			return
 		}
 
-		contentType := http.DetectContentType(buffer)
+		contentType := detectContentType(buffer[:n])
+
+		if matchImageFmt(contentType, unsupportedImageInputs) {
+			http.Error(w, "Unsupported image format "+contentType+".", http.StatusUnsupportedMediaType)
+			return
+		}
 
-		if !matchImageFmt(contentType) {
+		if !matchImageFmt(contentType, validImageInputs) {
 			http.Error(w, "Invalid image format. Only images are allowed.", http.StatusBadRequest)
+			return
 		}
 
 		ctx := context.WithValue(r.Context(), ImgFmt, strings.TrimPrefix(contentType, "image/"))
@@ -52,3 +81,52 @@ func ImageFmtValidatorMiddleware(next http.HandlerFunc) http.HandlerFunc {
 
 	}
 }
+
+type OwnerKey string
+
+const Owner OwnerKey = "owner"
+
+// AuthMiddleware authenticates requests carrying a bearer token and puts
+// the owner of the token in the context. Requests without a token go
+// through anonymously, with an empty owner.
+func AuthMiddleware(tokens map[string]string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), Owner, "")))
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		owner, known := tokens[token]
+
+		if !ok || !known {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Invalid token.", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), Owner, owner)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// AdminMiddleware only lets through requests carrying token as bearer
+// token. Without a token every request is refused.
+func AdminMiddleware(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "Admin endpoints are disabled.", http.StatusForbidden)
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Invalid token.", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ftyp is the start of an ISO-BMFF file of the given brand.
+func ftyp(brand string) []byte {
+	return append([]byte("\x00\x00\x00\x1cftyp"+brand+"\x00\x00\x00\x00mif1"), make([]byte, 16)...)
+}
+
+func TestDetectContentType(t *testing.T) {
+	assert := assert.New(t)
+
+	type testCase struct {
+		name         string
+		data         []byte
+		expectResult string
+	}
+
+	for _, scenario := range []testCase{
+		{name: "png", data: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), expectResult: "image/png"},
+		{name: "jpeg", data: []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), expectResult: "image/jpeg"},
+		{name: "webp", data: []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00"), expectResult: "image/webp"},
+		{name: "avif", data: ftyp("avif"), expectResult: "image/avif"},
+		{name: "avif sequence", data: ftyp("avis"), expectResult: "image/avif"},
+		{name: "heic", data: ftyp("heic"), expectResult: "application/octet-stream"},
+		{name: "short", data: []byte("\x00\x00\x00\x1cftyp"), expectResult: "application/octet-stream"},
+		{name: "text", data: []byte("hello"), expectResult: "text/plain; charset=utf-8"},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			assert.Equal(scenario.expectResult, detectContentType(scenario.data))
+		})
+	}
+}
+
+func TestImageFmtValidatorMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	type testCase struct {
+		name         string
+		data         []byte
+		expectResult int
+		expectFormat string
+	}
+
+	for _, scenario := range []testCase{
+		{name: "webp", data: []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00"), expectResult: http.StatusOK, expectFormat: "webp"},
+		{name: "avif", data: ftyp("avif"), expectResult: http.StatusUnsupportedMediaType},
+		{name: "text", data: []byte("hello"), expectResult: http.StatusBadRequest},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			file, err := form.CreateFormFile("file", "photo")
+			assert.NoError(err)
+			file.Write(scenario.data)
+			form.Close()
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/upload", &body)
+			r.Header.Set("Content-Type", form.FormDataContentType())
+
+			format := ""
+			w := httptest.NewRecorder()
+			ImageFmtValidatorMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				format = r.Context().Value(ImgFmt).(string)
+			})(w, r)
+
+			assert.Equal(scenario.expectResult, w.Code)
+			assert.Equal(scenario.expectFormat, format)
+		})
+	}
+}
@@ -1,61 +1,209 @@
 package ports
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"imageResizerX/adapters"
+	"imageResizerX/codec"
+	"imageResizerX/config"
+	"imageResizerX/domain"
 	"imageResizerX/logs"
 	"imageResizerX/middleware"
 	"imageResizerX/resizer"
+	"io"
 	"log"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"nhooyr.io/websocket"
 
 	"github.com/CloudyKit/jet/v6"
 )
 
+// Runner runs the jobs on a bounded set of workers, sharing them fairly
+// between clients and priorities. Submit fails with resizer.ErrQueueFull
+// when too many jobs are waiting already, RetryAfter estimates when there is
+// room again. A submitted job is dropped from the queue once its context is
+// done. Retry runs a job admitted already again after a wait, without
+// holding a worker meanwhile. Shutdown refuses new jobs with
+// resizer.ErrPoolClosed and waits for the others to finish.
 type Runner interface {
-	RunTask(task func())
+	Submit(ctx context.Context, task resizer.Task) error
+	Run(ctx context.Context, task resizer.Task) error
+	Retry(ctx context.Context, task resizer.Task, after time.Duration)
+	Stats() resizer.PoolStats
+	RetryAfter() time.Duration
+	Shutdown(ctx context.Context) error
 }
 
 type WebsocketHandler interface {
 	Handle(ctx context.Context, conn resizer.WebsocketConn) error
-	Brodcast(msg resizer.Message)
+	Publish(msg resizer.Message)
+	Shutdown()
 }
 
-type ImageServer func(w http.ResponseWriter, r *http.Request, filename string)
+// JobTracker follows the state of the jobs. The context returned by Create
+// is done once the job is cancelled. Complete and Fail tell whether they
+// finished the job, they leave a cancelled one as is.
+type JobTracker interface {
+	Create(id string, variants []domain.JobVariant) (domain.Job, context.Context)
+	Start(id string)
+	Complete(id string, variants []domain.JobVariant, expiresAt *time.Time) bool
+	Fail(id string, reason string) bool
+	Cancel(id string) (domain.Job, error)
+	Get(id string) (domain.Job, bool)
+}
+
+// Storage keeps the resized images. Open returns the image content, to be
+// closed by the caller, and every lookup of an unknown name fails with
+// domain.ErrObjectNotFound.
+type Storage interface {
+	resizer.Storer
+	Open(name string) (io.ReadCloser, domain.ObjectInfo, error)
+}
+
+// uploadResponse carries the download links too when the upload was already
+// processed before.
+type uploadResponse struct {
+	JobID       string              `json:"job_id"`
+	StatusUrl   string              `json:"status_url"`
+	DownloadUrl string              `json:"download_url,omitempty"`
+	Variants    []domain.JobVariant `json:"variants,omitempty"`
+}
 
 type httpApp struct {
 	runner           Runner
 	imageResize      *resizer.ImageResizer
 	websocketHandler WebsocketHandler
+	jobs             JobTracker
 	websocketOptions *websocket.AcceptOptions
-	imageServer      ImageServer
+	storage          Storage
+	records          resizer.RecordStore
+	resizeTimeout    time.Duration
+	jobTimeout       time.Duration
+	retry            resizer.RetryPolicy
+	deadLetters      *resizer.DeadLetters
+	presets          *resizer.Presets
+	imageTTL         time.Duration
+	maxImageTTL      time.Duration
+	storageQuota     int64
 }
 
-func NewHttpApp() *httpApp {
-	localDiskRepo := adapters.NewStorageInMemory()
+func NewHttpApp(cfg config.Config) (*httpApp, error) {
+	presets, err := resizer.LoadPresets(cfg.PresetsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := newStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := newRecordStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := newImagePool(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := resizer.RetryPolicy{
+		MaxAttempts: cfg.JobMaxAttempts,
+		Backoff:     cfg.RetryBackoff,
+		MaxBackoff:  cfg.RetryMaxBackoff,
+	}
 
-	return &httpApp{
-		runner:           resizer.NewImagePool(5),
-		imageResize:      resizer.NewImageResizer(localDiskRepo),
-		websocketHandler: resizer.DefaultwebsocketClient(),
+	app := &httpApp{
+		runner:           pool,
+		imageResize:      resizer.NewImageResizer(storage, records, cfg.SweepInterval, cfg.StorageQuota),
+		jobs:             resizer.NewJobRegistry(),
+		resizeTimeout:    time.Second * 30,
+		jobTimeout:       cfg.JobTimeout,
+		retry:            retry,
+		deadLetters:      resizer.NewDeadLetters(1000),
+		presets:          presets,
 		websocketOptions: &websocket.AcceptOptions{OriginPatterns: []string{"127.0.0.0"}},
-		imageServer: func(w http.ResponseWriter, r *http.Request, filename string) {
-			img, err := localDiskRepo.Retrieve(filename)
+		storage:          storage,
+		records:          records,
+		imageTTL:         cfg.ImageTTL,
+		maxImageTTL:      cfg.MaxImageTTL,
+		storageQuota:     cfg.StorageQuota,
+	}
+	app.websocketHandler = resizer.DefaultwebsocketClient(app.cancelJob)
 
-			if err != nil {
-				http.Error(w, "File not found", http.StatusNotFound)
-			}
+	if cfg.QueueDir != "" {
+		journal, err := adapters.NewDiskJobJournal(cfg.QueueDir)
+		if err != nil {
+			return nil, err
+		}
+
+		durable := resizer.NewDurableRunner(pool, journal)
+		app.runner = durable
 
-			w.Header().Set("Content-Type", "image/"+img.Format())
-			w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(filename))
-			http.ServeFile(w, r, img.FilePath)
-		},
+		if err := app.resume(durable); err != nil {
+			return nil, err
+		}
+	}
+
+	return app, nil
+}
+
+func newStorage(cfg config.Config) (Storage, error) {
+	switch cfg.Storage {
+	case "local":
+		return adapters.NewLocalStorage(cfg.StorageRoot)
+	case "memory":
+		return adapters.NewStorageInMemory(cfg.MemoryBudget)
+	case "s3":
+		return adapters.NewS3Storage(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+	}
+}
+
+func newImagePool(cfg config.Config) (*resizer.ImagePool, error) {
+	priorityWeights := make(map[resizer.Priority]int, len(cfg.PriorityWeights))
+	for name, weight := range cfg.PriorityWeights {
+		priority, err := resizer.ParsePriority(name)
+		if err != nil {
+			return nil, fmt.Errorf("PRIORITY_WEIGHTS: %w", err)
+		}
+		priorityWeights[priority] = weight
+	}
+
+	return resizer.NewImagePool(resizer.PoolConfig{
+		Workers:          cfg.Workers,
+		QueueSize:        cfg.QueueSize,
+		ClientMaxWorkers: cfg.ClientMaxWorkers,
+		ClientQueueSize:  cfg.ClientQueueSize,
+		PriorityWeights:  priorityWeights,
+		ClientWeights:    cfg.ClientWeights,
+	}), nil
+}
+
+func newRecordStore(cfg config.Config) (resizer.RecordStore, error) {
+	switch cfg.RecordStore {
+	case "json":
+		return adapters.NewJSONRecordStore(cfg.RecordPath)
+	case "bolt":
+		return adapters.NewBoltRecordStore(cfg.RecordPath)
+	case "memory":
+		return adapters.NewMemoryRecordStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown record store %q", cfg.RecordStore)
 	}
 }
 
@@ -67,25 +215,374 @@ func (a *httpApp) UploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	defer file.Close()
+
 	imageFmt := r.Context().Value(middleware.ImgFmt).(string)
 
-	a.runner.RunTask(func() {
-		message := resizer.Message{Action: "processing_failed", DownloadUrl: ""}
+	variants, err := parseVariants(r, imageFmt, a.presets)
 
-		out, err := a.imageResize.ResizeImage(
-			&resizer.Image{File: file, Filename: header.Filename, Format: imageFmt},
-			300,
-			200)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		if err == nil {
-			message.Action = "processing_complete"
-			message.DownloadUrl = "/api/v1/download/" + out
-		}
+	request, err := parseRequest(r)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request.Owner, _ = r.Context().Value(middleware.Owner).(string)
+	request.TTL, err = parseTTL(r, a.imageTTL, a.maxImageTTL, request.Owner)
+
+	if errors.Is(err, errForeverNeedsOwner) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	priority, err := resizer.ParsePriority(r.FormValue("priority"))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(file)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobVariants := make([]domain.JobVariant, len(variants))
+	for i, variant := range variants {
+		jobVariants[i] = domain.JobVariant{Name: variant.Name, Filter: variant.Resize.Filter}
+	}
+
+	job, ctx := a.jobs.Create(uuid.NewString(), jobVariants)
+	statusUrl := "/api/v1/jobs/" + job.ID
+
+	request.Variants = variants
+	original := &resizer.Image{Data: data, Filename: header.Filename, Format: imageFmt}
+
+	// identical uploads are answered with the stored results, no worker needed
+	if results, expiresAt, ok := a.imageResize.Lookup(original, request); ok {
+		stored, _ := a.completeJob(job.ID, jobVariants, results, expiresAt)
+		w.Header().Set("Location", statusUrl)
+		writeJSON(w, http.StatusOK, uploadResponse{
+			JobID:       job.ID,
+			StatusUrl:   statusUrl,
+			DownloadUrl: stored[0].DownloadUrl,
+			Variants:    stored,
+		})
+		return
+	}
+
+	// only the uploads stored anew need room
+	err = a.imageResize.CheckQuota()
+
+	if errors.Is(err, resizer.ErrQuotaExceeded) {
+		a.jobs.Fail(job.ID, err.Error())
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+
+	if err != nil {
+		a.jobs.Fail(job.ID, err.Error())
+		logs.Logger.Error("Failed to read storage usage", zap.Error(err))
+		http.Error(w, "Failed to read storage usage", http.StatusInternalServerError)
+		return
+	}
 
-		a.websocketHandler.Brodcast(message)
+	upload, err := newUploadJob(job.ID, client(r, request.Owner), priority, jobVariants, original, request)
+
+	if err != nil {
+		a.jobs.Fail(job.ID, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = a.runner.Submit(ctx, a.task(upload, 1))
+
+	if errors.Is(err, resizer.ErrQueueFull) {
+		a.jobs.Fail(job.ID, err.Error())
+		retryAfter := int(math.Ceil(a.runner.RetryAfter().Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "Too many images waiting to be processed, retry later.", http.StatusTooManyRequests)
+		return
+	}
+
+	if errors.Is(err, resizer.ErrPoolClosed) {
+		a.jobs.Fail(job.ID, err.Error())
+		http.Error(w, "Server is shutting down, retry later.", http.StatusServiceUnavailable)
+		return
+	}
+
+	if err != nil {
+		a.jobs.Fail(job.ID, err.Error())
+		logs.Logger.Error("Failed to queue job", zap.String("job_id", job.ID), zap.Error(err))
+		http.Error(w, "Failed to queue job", http.StatusInternalServerError)
+		return
+	}
 
+	w.Header().Set("Location", statusUrl)
+	writeJSON(w, http.StatusAccepted, uploadResponse{JobID: job.ID, StatusUrl: statusUrl})
+}
+
+// client names who the jobs of a request are shared fairly for: its owner,
+// or its address for anonymous uploads.
+func client(r *http.Request, owner string) string {
+	if owner != "" {
+		return owner
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// runInline runs the variants of a job on the worker of the job, a job never
+// waits on the queue it was taken from.
+func runInline(task func()) {
+	task()
+}
+
+// completeJob records the stored variants of a job and notifies its
+// subscribers. Nothing is recorded nor told when the job was cancelled
+// meanwhile, ok is false then.
+func (a *httpApp) completeJob(
+	jobID string,
+	jobVariants []domain.JobVariant,
+	results []resizer.VariantResult,
+	expiresAt *time.Time,
+) (stored []domain.JobVariant, ok bool) {
+	stored = make([]domain.JobVariant, len(results))
+	for i, result := range results {
+		stored[i] = jobVariants[i]
+		stored[i].DownloadUrl = "/api/v1/download/" + result.Filename
+	}
+
+	if !a.jobs.Complete(jobID, stored, expiresAt) {
+		return nil, false
+	}
+
+	a.websocketHandler.Publish(resizer.Message{
+		Action:      "processing_complete",
+		JobID:       jobID,
+		DownloadUrl: stored[0].DownloadUrl,
+		Variants:    stored,
 	})
 
+	return stored, true
+}
+
+// ResizeHandler resizes the uploaded image inline and answers with the
+// encoded result, without touching the storage.
+func (a *httpApp) ResizeHandler(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("file")
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	defer file.Close()
+
+	imageFmt := r.Context().Value(middleware.ImgFmt).(string)
+
+	variant, err := parseVariant(r, imageFmt, a.presets)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request, err := parseRequest(r)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(file)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.resizeTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	// resizeErr is a failure to decode or to resize the upload as asked,
+	// encodeErr one to write the result out
+	var resizeErr, encodeErr error
+
+	owner, _ := r.Context().Value(middleware.Owner).(string)
+	task := resizer.Task{Client: client(r, owner), Priority: resizer.PriorityInteractive}
+
+	task.Run = func(ctx context.Context) {
+		img, md, err := a.imageResize.Resize(
+			ctx,
+			&resizer.Image{Data: data, Filename: header.Filename, Format: imageFmt},
+			request,
+			variant.Resize)
+		if err != nil {
+			resizeErr = err
+			return
+		}
+
+		encodeErr = codec.Encode(&out, img, variant.Encoding, md)
+	}
+
+	err = a.runner.Run(ctx, task)
+
+	// the task gives up with ctx's error when it runs out of time
+	if err == nil && (errors.Is(resizeErr, context.DeadlineExceeded) || errors.Is(resizeErr, context.Canceled)) {
+		err, resizeErr = resizeErr, nil
+	}
+
+	if errors.Is(err, resizer.ErrPoolClosed) {
+		http.Error(w, "Server is shutting down, retry later.", http.StatusServiceUnavailable)
+		return
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		logs.Logger.Error("Synchronous resize timed out", zap.String("filename", header.Filename))
+		http.Error(w, "Resize timed out", http.StatusServiceUnavailable)
+		return
+	}
+
+	// the client went away, nobody is left to answer
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	if err != nil {
+		logs.Logger.Error("Synchronous resize failed", zap.String("filename", header.Filename), zap.Error(err))
+		http.Error(w, "Failed to resize the image", http.StatusInternalServerError)
+		return
+	}
+
+	if resizeErr != nil {
+		http.Error(w, resizeErr.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if encodeErr != nil {
+		logs.Logger.Error("Failed to encode the synchronous resize", zap.String("filename", header.Filename), zap.Error(encodeErr))
+		http.Error(w, "Failed to encode the image", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", codec.ContentType(variant.Encoding.Format))
+	w.Header().Set("Content-Length", strconv.Itoa(out.Len()))
+	out.WriteTo(w)
+}
+
+// Shutdown lets the queued and running jobs finish, refusing new ones, then
+// closes the WebSocket connections and the record store. It gives up with
+// ctx's error when the jobs do not finish in time.
+func (a *httpApp) Shutdown(ctx context.Context) error {
+	err := a.runner.Shutdown(ctx)
+	a.websocketHandler.Shutdown()
+
+	if closer, ok := a.records.(io.Closer); ok && err == nil {
+		closer.Close()
+	}
+
+	return err
+}
+
+// usageResponse is the storage usage with the quota it is held to, zero
+// when there is none.
+type usageResponse struct {
+	domain.StorageUsage
+	Quota int64 `json:"quota"`
+}
+
+func (a *httpApp) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	usage, err := a.storage.Usage()
+	if err != nil {
+		logs.Logger.Error("Failed to read storage usage", zap.Error(err))
+		http.Error(w, "Failed to read storage usage", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, usageResponse{StorageUsage: usage, Quota: a.storageQuota})
+}
+
+func (a *httpApp) QueueHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.runner.Stats())
+}
+
+// DeadLettersHandler lists the jobs that failed after their last attempt,
+// oldest first.
+func (a *httpApp) DeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.deadLetters.List())
+}
+
+func (a *httpApp) PresetsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.presets.All())
+}
+
+func (a *httpApp) JobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathParam(w, r)
+	if !ok {
+		return
+	}
+
+	job, ok := a.jobs.Get(id)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// CancelJobHandler stops a job that is queued or running. Its outputs
+// stored meanwhile are removed.
+func (a *httpApp) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.cancelJob(id)
+
+	if errors.Is(err, resizer.ErrJobNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	if errors.Is(err, resizer.ErrJobFinished) {
+		http.Error(w, "Job already finished", http.StatusConflict)
+		return
+	}
+
+	job, _ := a.jobs.Get(id)
+	writeJSON(w, http.StatusOK, job)
+}
+
+// cancelJob cancels a job and notifies its subscribers. A queued job is
+// dropped from the queue, a running one stops at its next checkpoint.
+func (a *httpApp) cancelJob(id string) error {
+	if _, err := a.jobs.Cancel(id); err != nil {
+		return err
+	}
+
+	a.websocketHandler.Publish(resizer.Message{Action: "processing_cancelled", JobID: id})
+	return nil
 }
 
 func (a *httpApp) WebsocketHandler(w http.ResponseWriter, r *http.Request) {
@@ -118,23 +615,83 @@ func (a *httpApp) WebsocketHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *httpApp) DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	filename, ok := pathParam(w, r)
+	if !ok {
+		return
+	}
+
+	record, err := a.records.Get(filename)
+
+	if errors.Is(err, domain.ErrRecordNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		logs.Logger.Error("Failed to read image record", zap.String("filename", filename), zap.Error(err))
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	if record.IsExpired(time.Now()) {
+		http.Error(w, "File expired", http.StatusGone)
+		return
+	}
+
+	body, info, err := a.storage.Open(filename)
+
+	if errors.Is(err, domain.ErrObjectNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		logs.Logger.Error("Failed to open stored image", zap.String("filename", filename), zap.Error(err))
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	defer body.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": record.DownloadFilename}))
+	w.Header().Set("ETag", strconv.Quote(record.Checksum))
+
+	// local files can be served with range and conditional requests
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, filename, info.ModTime, seeker)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, body)
+}
+
+// pathParam returns the last segment of urls like /api/v1/<resource>/<param>.
+func pathParam(w http.ResponseWriter, r *http.Request) (string, bool) {
 	segments := strings.Split(r.URL.Path, "/")
 
 	if len(segments) < 5 {
 		logs.Logger.Error("Invalid Url", zap.String("url", r.URL.Path))
 		http.Error(w, "Invalid Url", http.StatusBadGateway)
-		return
+		return "", false
 	}
 
-	filename := segments[len(segments)-1]
+	param := segments[len(segments)-1]
 
-	if filename == "" {
+	if param == "" {
 		logs.Logger.Error("Path param should not be empty")
 		http.Error(w, "Invalid Url", http.StatusBadGateway)
-		return
+		return "", false
 	}
 
-	a.imageServer(w, r, filename)
+	return param, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
 }
 
 var views = jet.NewSet(
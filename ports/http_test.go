@@ -0,0 +1,262 @@
+package ports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"imageResizerX/config"
+	"imageResizerX/domain"
+	"imageResizerX/middleware"
+	"imageResizerX/resizer"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestApp builds the app from the default configuration with the
+// memory storage, the variables of env set on top.
+func newTestApp(t *testing.T, env map[string]string) *httpApp {
+	t.Setenv("STORAGE", "memory")
+	t.Setenv("PRESETS_FILE", filepath.Join(t.TempDir(), "presets.json"))
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	cfg, err := config.FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewHttpApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		app.Shutdown(ctx)
+	})
+	return app
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	var out bytes.Buffer
+	if err := png.Encode(&out, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// uploadRequest posts data as the file of a multipart form holding fields.
+func uploadRequest(t *testing.T, target string, data []byte, fields map[string]string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	file, err := form.CreateFormFile("file", "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(data)
+
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+// upload posts data to the upload handler.
+func upload(t *testing.T, app *httpApp, data []byte, fields map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	middleware.ImageFmtValidatorMiddleware(app.UploadHandler)(w, uploadRequest(t, "/api/v1/upload", data, fields))
+	return w
+}
+
+// waitJob polls the job until it is no longer queued or processing.
+func waitJob(t *testing.T, app *httpApp, id string) domain.Job {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		job, ok := app.jobs.Get(id)
+		if ok && job.State != domain.JobQueued && job.State != domain.JobProcessing {
+			return job
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	t.Fatalf("job %s did not finish", id)
+	return domain.Job{}
+}
+
+// getJob asks the job handler for the job at statusUrl.
+func getJob(t *testing.T, app *httpApp, statusUrl string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	app.JobHandler(w, httptest.NewRequest(http.MethodGet, statusUrl, nil))
+	return w
+}
+
+func TestUploadHandler(t *testing.T) {
+	assert := assert.New(t)
+	app := newTestApp(t, nil)
+
+	w := upload(t, app, testPNG(t, 40, 20), map[string]string{"width": "10"})
+	assert.Equal(http.StatusAccepted, w.Code)
+
+	var response uploadResponse
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotEmpty(response.JobID)
+	assert.Equal("/api/v1/jobs/"+response.JobID, response.StatusUrl)
+	assert.Equal(response.StatusUrl, w.Header().Get("Location"))
+
+	waitJob(t, app, response.JobID)
+
+	w = getJob(t, app, response.StatusUrl)
+	assert.Equal(http.StatusOK, w.Code)
+
+	var job domain.Job
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &job))
+	assert.Equal(response.JobID, job.ID)
+	assert.Equal(domain.JobComplete, job.State)
+	assert.NotEmpty(job.DownloadUrl)
+	assert.Len(job.Variants, 1)
+
+	w = getJob(t, app, "/api/v1/jobs/unknown")
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = upload(t, app, testPNG(t, 40, 20), map[string]string{"width": "-1"})
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestDownloadHandler(t *testing.T) {
+	assert := assert.New(t)
+	app := newTestApp(t, nil)
+
+	download := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.DownloadHandler(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	w := upload(t, app, testPNG(t, 40, 20), map[string]string{"width": "10"})
+	var response uploadResponse
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	job := waitJob(t, app, response.JobID)
+
+	w = download(job.DownloadUrl)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("image/png", w.Header().Get("Content-Type"))
+
+	name := path.Base(job.DownloadUrl)
+	record, err := app.records.Get(name)
+	assert.NoError(err)
+	expired := time.Now().Add(-time.Minute)
+	record.ExpiresAt = &expired
+	assert.NoError(app.records.Put(record))
+
+	w = download(job.DownloadUrl)
+	assert.Equal(http.StatusGone, w.Code)
+
+	w = download("/api/v1/download/unknown.png")
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestUploadHandlerQuota(t *testing.T) {
+	assert := assert.New(t)
+	app := newTestApp(t, map[string]string{"STORAGE_QUOTA": "1"})
+	data := testPNG(t, 40, 20)
+
+	w := upload(t, app, data, map[string]string{"width": "10"})
+	assert.Equal(http.StatusAccepted, w.Code)
+
+	var response uploadResponse
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(domain.JobComplete, waitJob(t, app, response.JobID).State)
+
+	// served from the stored results, nothing new is written
+	w = upload(t, app, data, map[string]string{"width": "10"})
+	assert.Equal(http.StatusOK, w.Code)
+
+	w = upload(t, app, data, map[string]string{"width": "20"})
+	assert.Equal(http.StatusInsufficientStorage, w.Code)
+}
+
+func TestUploadHandlerQueueFull(t *testing.T) {
+	assert := assert.New(t)
+	app := newTestApp(t, map[string]string{"WORKERS": "1", "QUEUE_SIZE": "0"})
+
+	// the only worker is busy and nothing may wait for it
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	err := app.runner.Submit(context.Background(), resizer.Task{Client: "bob", Run: func(ctx context.Context) {
+		close(started)
+		<-release
+	}})
+	assert.NoError(err)
+	<-started
+
+	w := upload(t, app, testPNG(t, 40, 20), map[string]string{"width": "10"})
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Equal("1", w.Header().Get("Retry-After"))
+}
+
+// expiredRunner runs the tasks right away with a context past its deadline,
+// as a task picked by a worker right when the request times out.
+type expiredRunner struct {
+	Runner
+}
+
+func (r expiredRunner) Run(ctx context.Context, task resizer.Task) error {
+	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+
+	task.Run(ctx)
+	return nil
+}
+
+func TestResizeHandler(t *testing.T) {
+	type testCase struct {
+		name         string
+		data         []byte
+		fields       map[string]string
+		expired      bool
+		expectResult int
+	}
+
+	for _, scenario := range []testCase{
+		{name: "resized", data: testPNG(t, 40, 20), fields: map[string]string{"width": "10"}, expectResult: http.StatusOK},
+		{name: "undecodable", data: append(testPNG(t, 40, 20)[:40], "garbage"...), fields: map[string]string{"width": "10"}, expectResult: http.StatusUnprocessableEntity},
+		{name: "crop outside", data: testPNG(t, 40, 20), fields: map[string]string{"width": "10", "operations": `[{"op": "crop", "x": 100, "y": 100, "width": 10, "height": 10}]`}, expectResult: http.StatusUnprocessableEntity},
+		{name: "timed out", data: testPNG(t, 40, 20), fields: map[string]string{"width": "10"}, expired: true, expectResult: http.StatusServiceUnavailable},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			assert := assert.New(t)
+			app := newTestApp(t, nil)
+			if scenario.expired {
+				app.runner = expiredRunner{app.runner}
+			}
+
+			w := httptest.NewRecorder()
+			middleware.ImageFmtValidatorMiddleware(app.ResizeHandler)(w, uploadRequest(t, "/api/v1/resize", scenario.data, scenario.fields))
+
+			assert.Equal(scenario.expectResult, w.Code)
+			if scenario.expectResult == http.StatusOK {
+				assert.Equal("image/png", w.Header().Get("Content-Type"))
+				config, err := png.DecodeConfig(w.Body)
+				assert.NoError(err)
+				assert.Equal(10, config.Width)
+			}
+		})
+	}
+}
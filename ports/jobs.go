@@ -0,0 +1,224 @@
+package ports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"imageResizerX/domain"
+	"imageResizerX/logs"
+	"imageResizerX/resizer"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxInterruptions is how many restarts a job may be running at before it
+// is failed instead of run again, so an upload crashing the server does not
+// crash it on every start.
+const maxInterruptions = 3
+
+var (
+	// errProcessingTimeout is the reason of the jobs that took longer than
+	// the job timeout.
+	errProcessingTimeout = errors.New("processing_timeout")
+	// errProcessingInterrupted is the reason of the jobs given up after too
+	// many restarts.
+	errProcessingInterrupted = errors.New("processing_interrupted")
+)
+
+// jobParams is what a queued job needs, besides its source, to run again
+// after a restart.
+type jobParams struct {
+	Request  resizer.Request     `json:"request"`
+	Filename string              `json:"filename"`
+	Format   string              `json:"format"`
+	Variants []domain.JobVariant `json:"variants"`
+}
+
+// uploadJob is an upload to process in the background, with all it takes
+// to run it again.
+type uploadJob struct {
+	id       string
+	client   string
+	priority resizer.Priority
+	variants []domain.JobVariant
+	original *resizer.Image
+	request  resizer.Request
+	// queued describes the job for the runner to write it down.
+	queued *domain.QueuedJob
+}
+
+// newUploadJob describes the upload, a job the runner can write down.
+func newUploadJob(
+	id string,
+	client string,
+	priority resizer.Priority,
+	variants []domain.JobVariant,
+	original *resizer.Image,
+	request resizer.Request,
+) (uploadJob, error) {
+	params, err := json.Marshal(jobParams{
+		Request:  request,
+		Filename: original.Filename,
+		Format:   original.Format,
+		Variants: variants,
+	})
+	if err != nil {
+		return uploadJob{}, err
+	}
+
+	return uploadJob{
+		id:       id,
+		client:   client,
+		priority: priority,
+		variants: variants,
+		original: original,
+		request:  request,
+		queued: &domain.QueuedJob{
+			ID:       id,
+			Client:   client,
+			Priority: string(priority),
+			Params:   params,
+			Source:   original.Data,
+			QueuedAt: time.Now(),
+		},
+	}, nil
+}
+
+// task runs the given attempt of job, counted from 1.
+func (a *httpApp) task(job uploadJob, attempt int) resizer.Task {
+	return resizer.Task{
+		Client:   job.client,
+		Priority: job.priority,
+		Job:      job.queued,
+		Run: func(ctx context.Context) {
+			a.runJob(ctx, job, attempt)
+		},
+	}
+}
+
+// runJob processes and stores the variants of job, then completes it and
+// notifies its subscribers. A job failing with a transient error is handed
+// back to the runner to run again after a backoff, until it runs out of
+// attempts and is moved to the dead letters.
+func (a *httpApp) runJob(ctx context.Context, job uploadJob, attempt int) {
+	results, err := a.process(ctx, job.id, job.original, job.request)
+
+	// the cancellation was already told by cancelJob
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		logs.Logger.Warn("Job timed out", zap.String("job_id", job.id), zap.Duration("timeout", a.jobTimeout))
+		err = errProcessingTimeout
+	}
+
+	if err == nil {
+		// cancelled after its last step, the outputs are not wanted any more
+		if _, ok := a.completeJob(job.id, job.variants, results, job.request.Expiry(time.Now())); !ok {
+			a.imageResize.Discard(results)
+		}
+		return
+	}
+
+	if !a.retry.Retry(attempt, err) {
+		if a.failJob(job.id, err) && errors.Is(err, resizer.ErrTransient) {
+			logs.Logger.Error("Job ran out of attempts", zap.String("job_id", job.id), zap.Int("attempts", attempt), zap.Error(err))
+			a.deadLetters.Add(domain.DeadLetter{
+				JobID:    job.id,
+				Client:   job.client,
+				Filename: job.original.Filename,
+				Attempts: attempt,
+				Error:    err.Error(),
+				FailedAt: time.Now(),
+			})
+		}
+		return
+	}
+
+	delay := a.retry.Delay(attempt)
+	logs.Logger.Warn("Retrying job", zap.String("job_id", job.id), zap.Int("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+
+	a.runner.Retry(ctx, a.task(job, attempt+1), delay)
+}
+
+// process runs one attempt of a job, within the job timeout.
+func (a *httpApp) process(
+	ctx context.Context,
+	jobID string,
+	original *resizer.Image,
+	request resizer.Request,
+) (results []resizer.VariantResult, err error) {
+	ctx, cancel := context.WithTimeout(ctx, a.jobTimeout)
+	defer cancel()
+
+	a.jobs.Start(jobID)
+
+	// done is called before Process returns, the variants run inline
+	a.imageResize.Process(ctx, original, request, runInline, func(r []resizer.VariantResult, processErr error) {
+		results, err = r, processErr
+	})
+
+	return results, err
+}
+
+// failJob fails a job and notifies its subscribers, unless it was cancelled
+// meanwhile. It tells whether the job was failed.
+func (a *httpApp) failJob(jobID string, err error) bool {
+	if !a.jobs.Fail(jobID, err.Error()) {
+		return false
+	}
+
+	a.websocketHandler.Publish(resizer.Message{Action: "processing_failed", JobID: jobID, DownloadUrl: "", Error: err.Error()})
+	return true
+}
+
+// resume queues again the jobs a previous run of the server left
+// unfinished, under their former ids. The ones interrupted too many times
+// are failed.
+func (a *httpApp) resume(durable *resizer.DurableRunner) error {
+	queued, err := durable.Recover()
+	if err != nil {
+		return err
+	}
+
+	for _, queuedJob := range queued {
+		var params jobParams
+		if err := json.Unmarshal(queuedJob.Params, &params); err != nil {
+			logs.Logger.Error("Failed to read queued job", zap.String("job_id", queuedJob.ID), zap.Error(err))
+			durable.Forget(queuedJob.ID)
+			continue
+		}
+
+		job, ctx := a.jobs.Create(queuedJob.ID, params.Variants)
+
+		if queuedJob.Interruptions > maxInterruptions {
+			logs.Logger.Warn("Giving up interrupted job", zap.String("job_id", job.ID), zap.Int("interruptions", queuedJob.Interruptions))
+			a.failJob(job.ID, errProcessingInterrupted)
+			durable.Forget(job.ID)
+			continue
+		}
+
+		priority, _ := resizer.ParsePriority(queuedJob.Priority)
+		queuedJob := queuedJob
+
+		upload := uploadJob{
+			id:       job.ID,
+			client:   queuedJob.Client,
+			priority: priority,
+			variants: params.Variants,
+			original: &resizer.Image{Data: queuedJob.Source, Filename: params.Filename, Format: params.Format},
+			request:  params.Request,
+			queued:   &queuedJob,
+		}
+
+		if err := durable.Resume(ctx, a.task(upload, 1)); err != nil {
+			return err
+		}
+
+		logs.Logger.Info("Resumed queued job", zap.String("job_id", job.ID), zap.String("state", string(queuedJob.State)))
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package ports
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"imageResizerX/adapters"
+	"imageResizerX/domain"
+	"imageResizerX/resizer"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// websocketStub records the messages published.
+type websocketStub struct {
+	messages []resizer.Message
+	lock     sync.Mutex
+}
+
+func (s *websocketStub) Handle(ctx context.Context, conn resizer.WebsocketConn) error {
+	return nil
+}
+
+func (s *websocketStub) Publish(msg resizer.Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = append(s.messages, msg)
+}
+
+func (s *websocketStub) Shutdown() {}
+
+// lateCancelTracker cancels every job right before it is completed, as a
+// cancel landing once the job stored its outputs.
+type lateCancelTracker struct {
+	*resizer.JobRegistry
+}
+
+func (t lateCancelTracker) Complete(id string, variants []domain.JobVariant, expiresAt *time.Time) bool {
+	t.Cancel(id)
+	return t.JobRegistry.Complete(id, variants, expiresAt)
+}
+
+func testUpload(t *testing.T, id string) uploadJob {
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewNRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	variants := []domain.JobVariant{{Name: resizer.DefaultVariant, Filter: "box"}}
+	request := resizer.Request{Variants: []resizer.Variant{{
+		Name:     resizer.DefaultVariant,
+		Resize:   resizer.ResizeOptions{Width: 10, Height: 10, Filter: "box"},
+		Encoding: domain.EncodeOptions{Format: "png"},
+	}}}
+
+	upload, err := newUploadJob(id, "alice", resizer.PriorityInteractive, variants, &resizer.Image{Data: data.Bytes(), Filename: "photo.png", Format: "png"}, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return upload
+}
+
+func TestRunJobCancelledBeforeComplete(t *testing.T) {
+	assert := assert.New(t)
+
+	storage, err := adapters.NewStorageInMemory(1 << 20)
+	assert.NoError(err)
+	records, err := adapters.NewJSONRecordStore(t.TempDir())
+	assert.NoError(err)
+
+	websocket := &websocketStub{}
+	app := &httpApp{
+		imageResize:      resizer.NewImageResizer(storage, records, time.Hour, 0),
+		jobs:             lateCancelTracker{resizer.NewJobRegistry()},
+		websocketHandler: websocket,
+		jobTimeout:       time.Minute,
+		retry:            resizer.RetryPolicy{MaxAttempts: 1},
+		deadLetters:      resizer.NewDeadLetters(10),
+	}
+
+	_, ctx := app.jobs.Create("job-1", nil)
+	app.runJob(ctx, testUpload(t, "job-1"), 1)
+
+	job, _ := app.jobs.Get("job-1")
+	assert.Equal(domain.JobCancelled, job.State)
+	assert.Empty(websocket.messages)
+
+	objects, err := storage.List()
+	assert.NoError(err)
+	assert.Empty(objects)
+	stored, err := records.List()
+	assert.NoError(err)
+	assert.Empty(stored)
+}
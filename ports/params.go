@@ -0,0 +1,257 @@
+package ports
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imageResizerX/codec"
+	"imageResizerX/domain"
+	"imageResizerX/resizer"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func parseDimension(r *http.Request, field string) (int, error) {
+	value := r.FormValue(field)
+	if value == "" {
+		return 0, nil
+	}
+
+	dimension, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", field)
+	}
+
+	return dimension, nil
+}
+
+func parseResizeOptions(r *http.Request) (resizer.ResizeOptions, error) {
+	width, err := parseDimension(r, "width")
+	if err != nil {
+		return resizer.ResizeOptions{}, err
+	}
+
+	height, err := parseDimension(r, "height")
+	if err != nil {
+		return resizer.ResizeOptions{}, err
+	}
+
+	fit, err := resizer.ParseFitMode(r.FormValue("fit"))
+	if err != nil {
+		return resizer.ResizeOptions{}, err
+	}
+
+	filter, err := resizer.ParseFilter(r.FormValue("filter"))
+	if err != nil {
+		return resizer.ResizeOptions{}, err
+	}
+
+	opts := resizer.ResizeOptions{Width: width, Height: height, Fit: fit, Filter: filter}
+
+	if width == 0 && height == 0 {
+		opts = resizer.DefaultResizeOptions()
+		opts.Fit = fit
+		opts.Filter = filter
+	}
+
+	if err := opts.Validate(); err != nil {
+		return resizer.ResizeOptions{}, err
+	}
+
+	return opts, nil
+}
+
+// parseEncodeOptions reads the requested output encoding. Without an
+// explicit format codec.OutputFormat picks one from the input format.
+func parseEncodeOptions(r *http.Request, inputFormat string) (domain.EncodeOptions, error) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = codec.OutputFormat(inputFormat)
+	}
+
+	opts := domain.EncodeOptions{
+		Format:      codec.NormalizeFormat(format),
+		Compression: r.FormValue("compression"),
+	}
+
+	if quality := r.FormValue("quality"); quality != "" {
+		q, err := strconv.Atoi(quality)
+		if err != nil {
+			return domain.EncodeOptions{}, fmt.Errorf("quality must be an integer")
+		}
+		opts.Quality = q
+	}
+
+	if err := codec.Validate(opts); err != nil {
+		return domain.EncodeOptions{}, err
+	}
+
+	return opts, nil
+}
+
+// variantSpec is one entry of the variants field. It either names a
+// server side preset or carries the parameters inline.
+type variantSpec struct {
+	Name       string `json:"name"`
+	PresetName string `json:"preset"`
+	resizer.Preset
+}
+
+func lookupPreset(presets *resizer.Presets, name string) (resizer.Preset, error) {
+	preset, ok := presets.Get(name)
+	if !ok {
+		return resizer.Preset{}, fmt.Errorf("unknown preset %q", name)
+	}
+	return preset, nil
+}
+
+// parseVariant reads the single output of an upload, either from the preset
+// field or from the plain form fields.
+func parseVariant(r *http.Request, inputFormat string, presets *resizer.Presets) (resizer.Variant, error) {
+	if name := r.FormValue("preset"); name != "" {
+		preset, err := lookupPreset(presets, name)
+		if err != nil {
+			return resizer.Variant{}, err
+		}
+
+		variant, err := preset.Variant(name, inputFormat)
+		if err != nil {
+			return resizer.Variant{}, err
+		}
+
+		return variant, resizer.ValidateVariants([]resizer.Variant{variant})
+	}
+
+	opts, err := parseResizeOptions(r)
+	if err != nil {
+		return resizer.Variant{}, err
+	}
+
+	encoding, err := parseEncodeOptions(r, inputFormat)
+	if err != nil {
+		return resizer.Variant{}, err
+	}
+
+	return resizer.Variant{Name: resizer.DefaultVariant, Resize: opts, Encoding: encoding}, nil
+}
+
+// parseVariants reads the JSON list of outputs from the variants field.
+// Uploads without it get the single variant of parseVariant.
+func parseVariants(r *http.Request, inputFormat string, presets *resizer.Presets) ([]resizer.Variant, error) {
+	raw := r.FormValue("variants")
+
+	if raw == "" {
+		variant, err := parseVariant(r, inputFormat, presets)
+		if err != nil {
+			return nil, err
+		}
+
+		return []resizer.Variant{variant}, nil
+	}
+
+	var specs []variantSpec
+	if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+		return nil, fmt.Errorf("variants must be a JSON list: %w", err)
+	}
+
+	variants := make([]resizer.Variant, 0, len(specs))
+
+	for _, spec := range specs {
+		preset := spec.Preset
+
+		if spec.PresetName != "" {
+			named, err := lookupPreset(presets, spec.PresetName)
+			if err != nil {
+				return nil, fmt.Errorf("variant %s: %w", spec.Name, err)
+			}
+			preset = named
+		}
+
+		variant, err := preset.Variant(spec.Name, inputFormat)
+		if err != nil {
+			return nil, fmt.Errorf("variant %s: %w", spec.Name, err)
+		}
+
+		variants = append(variants, variant)
+	}
+
+	if err := resizer.ValidateVariants(variants); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// parseOperations reads the JSON list of operations run on the image
+// before it is resized, e.g. [{"op": "rotate", "angle": 90}].
+func parseOperations(r *http.Request) (resizer.Pipeline, error) {
+	raw := r.FormValue("operations")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var operations resizer.Pipeline
+	if err := json.Unmarshal([]byte(raw), &operations); err != nil {
+		return nil, fmt.Errorf("operations must be a JSON list: %w", err)
+	}
+
+	if err := operations.Validate(); err != nil {
+		return nil, err
+	}
+
+	return operations, nil
+}
+
+// parseRequest reads the fields shared by the upload and resize endpoints:
+// operations, auto_orient (on unless false) and the metadata policy.
+func parseRequest(r *http.Request) (resizer.Request, error) {
+	operations, err := parseOperations(r)
+	if err != nil {
+		return resizer.Request{}, err
+	}
+
+	autoOrient := true
+	if value := r.FormValue("auto_orient"); value != "" {
+		autoOrient, err = strconv.ParseBool(value)
+		if err != nil {
+			return resizer.Request{}, fmt.Errorf("auto_orient must be true or false")
+		}
+	}
+
+	policy, err := codec.ParseMetadataPolicy(r.FormValue("metadata"))
+	if err != nil {
+		return resizer.Request{}, err
+	}
+
+	return resizer.Request{Operations: operations, AutoOrient: autoOrient, Metadata: policy}, nil
+}
+
+var errForeverNeedsOwner = errors.New("ttl forever is only allowed to authenticated uploads")
+
+// parseTTL reads how long the outputs of an upload are kept, a duration like
+// "30m" up to maxTTL or "forever" for authenticated owners.
+func parseTTL(r *http.Request, defaultTTL time.Duration, maxTTL time.Duration, owner string) (time.Duration, error) {
+	value := r.FormValue("ttl")
+
+	switch value {
+	case "":
+		return defaultTTL, nil
+	case "forever":
+		if owner == "" {
+			return 0, errForeverNeedsOwner
+		}
+		return resizer.KeepForever, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("ttl must be a positive duration like 30m or forever")
+	}
+
+	if ttl > maxTTL {
+		return 0, fmt.Errorf("ttl must not be longer than %s", maxTTL)
+	}
+
+	return ttl, nil
+}
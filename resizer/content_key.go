@@ -0,0 +1,72 @@
+package resizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"imageResizerX/codec"
+	"imageResizerX/domain"
+)
+
+// keyParams are the parameters that change the output of a variant, with
+// their defaults filled in so equivalent requests get the same key.
+type keyParams struct {
+	Operations Pipeline              `json:"operations"`
+	AutoOrient bool                  `json:"auto_orient"`
+	Metadata   domain.MetadataPolicy `json:"metadata"`
+	Width      int                   `json:"width"`
+	Height     int                   `json:"height"`
+	Fit        FitMode               `json:"fit"`
+	Filter     string                `json:"filter"`
+	Format     string                `json:"format"`
+	Quality    int                   `json:"quality"`
+	// Compression only matters to PNG outputs
+	Compression string `json:"compression"`
+}
+
+// newKeyParams returns the normalized parameters of variant.
+func newKeyParams(req Request, variant Variant) keyParams {
+	fit, _ := ParseFitMode(string(variant.Resize.Fit))
+	filter, _ := ParseFilter(variant.Resize.Filter)
+	metadata, _ := codec.ParseMetadataPolicy(string(req.Metadata))
+
+	params := keyParams{
+		Operations: req.Operations,
+		AutoOrient: req.AutoOrient,
+		Metadata:   metadata,
+		Width:      variant.Resize.Width,
+		Height:     variant.Resize.Height,
+		Fit:        fit,
+		Filter:     filter,
+		Format:     codec.NormalizeFormat(variant.Encoding.Format),
+		Quality:    variant.Encoding.Quality,
+	}
+
+	if params.Format == "png" {
+		params.Compression = variant.Encoding.Compression
+		if params.Compression == "" {
+			params.Compression = "default"
+		}
+	}
+
+	if params.Format != "jpeg" {
+		params.Quality = 0
+	}
+
+	return params
+}
+
+// contentKey identifies the output of variant for the source image bytes:
+// the hex sha256 of the source hash followed by the normalized parameters
+// and the owner, outputs are not shared between owners.
+func contentKey(source []byte, req Request, variant Variant) string {
+	encoded, _ := json.Marshal(newKeyParams(req, variant))
+
+	sourceHash := sha256.Sum256(source)
+
+	hash := sha256.New()
+	hash.Write(sourceHash[:])
+	hash.Write(encoded)
+	hash.Write([]byte(req.Owner))
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,126 @@
+package resizer
+
+import (
+	"context"
+	"imageResizerX/domain"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentKey(t *testing.T) {
+	assert := assert.New(t)
+
+	source := []byte("image")
+	variant := Variant{Name: "thumb", Resize: ResizeOptions{Width: 10}, Encoding: domain.EncodeOptions{Format: "jpg"}}
+	key := contentKey(source, Request{}, variant)
+
+	type testCase struct {
+		name        string
+		source      []byte
+		req         Request
+		variant     Variant
+		expectEqual bool
+	}
+
+	for _, scenario := range []testCase{
+		{
+			name:        "defaults spelled out",
+			req:         Request{Metadata: domain.MetadataStrip},
+			variant:     Variant{Name: "other", Resize: ResizeOptions{Width: 10, Fit: "stretch", Filter: "LANCZOS"}, Encoding: domain.EncodeOptions{Format: "jpeg"}},
+			expectEqual: true,
+		},
+		{name: "other source", source: []byte("image2"), variant: variant},
+		{name: "other width", variant: Variant{Resize: ResizeOptions{Width: 11}, Encoding: variant.Encoding}},
+		{name: "other format", variant: Variant{Resize: variant.Resize, Encoding: domain.EncodeOptions{Format: "png"}}},
+		{name: "operations", req: Request{Operations: Pipeline{{Op: OpInvert}}}, variant: variant},
+		{name: "auto orient", req: Request{AutoOrient: true}, variant: variant},
+		{name: "owner", req: Request{Owner: "alice"}, variant: variant},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			if scenario.source == nil {
+				scenario.source = source
+			}
+
+			result := contentKey(scenario.source, scenario.req, scenario.variant)
+			assert.Equal(scenario.expectEqual, result == key)
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	assert := assert.New(t)
+	storer := NewStoreStub()
+	resizer := newTestResizer(storer)
+
+	original := &Image{Data: []byte("photo"), Filename: "photo.png", Format: "png"}
+	req := Request{Variants: []Variant{
+		{Name: "thumb", Resize: ResizeOptions{Width: 10}, Encoding: domain.EncodeOptions{Format: "png"}},
+		{Name: "large", Resize: ResizeOptions{Width: 30}, Encoding: domain.EncodeOptions{Format: "png"}},
+	}}
+
+	_, _, ok := resizer.Lookup(original, req)
+	assert.False(ok)
+
+	var processed []VariantResult
+	resizer.Process(context.Background(), original, req, runNow, func(results []VariantResult, err error) {
+		processed = results
+	})
+
+	results, expiresAt, ok := resizer.Lookup(original, req)
+	assert.True(ok)
+	assert.Equal(processed, results)
+	assert.WithinDuration(time.Now().Add(domain.ImageLifetime), *expiresAt, time.Second)
+
+	req.Owner = "alice"
+	_, _, ok = resizer.Lookup(original, req)
+	assert.False(ok)
+
+	req.Owner = ""
+	resizer.now = func() time.Time { return time.Now().Add(domain.ImageLifetime) }
+	_, _, ok = resizer.Lookup(original, req)
+	assert.False(ok)
+}
+
+func TestLookupExtendsExpiry(t *testing.T) {
+	assert := assert.New(t)
+	storer := NewStoreStub()
+	resizer := newTestResizer(storer)
+
+	original := &Image{Data: []byte("photo"), Filename: "photo.png", Format: "png"}
+	req := Request{Owner: "alice", TTL: time.Minute, Variants: []Variant{
+		{Name: "thumb", Resize: ResizeOptions{Width: 10}, Encoding: domain.EncodeOptions{Format: "png"}},
+	}}
+
+	resizer.Process(context.Background(), original, req, runNow, func(results []VariantResult, err error) {
+		assert.NoError(err)
+	})
+
+	req.TTL = time.Hour
+	_, expiresAt, ok := resizer.Lookup(original, req)
+	assert.True(ok)
+	assert.WithinDuration(time.Now().Add(time.Hour), *expiresAt, time.Second)
+
+	// a shorter ttl does not shorten what was asked before
+	req.TTL = time.Second
+	_, expiresAt, _ = resizer.Lookup(original, req)
+	assert.WithinDuration(time.Now().Add(time.Hour), *expiresAt, time.Second)
+
+	req.TTL = KeepForever
+	results, expiresAt, ok := resizer.Lookup(original, req)
+	assert.True(ok)
+	assert.Nil(expiresAt)
+
+	record, _ := resizer.records.Get(results[0].Filename)
+	assert.Nil(record.ExpiresAt)
+	assert.False(record.IsExpired(time.Now().Add(time.Hour * 24 * 365)))
+}
+
+func TestDownloadFilename(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("photo.png", downloadFilename("photo.jpg", Variant{Name: DefaultVariant, Encoding: domain.EncodeOptions{Format: "png"}}))
+	assert.Equal("photo_thumb.jpeg", downloadFilename("photo.jpg", Variant{Name: "thumb", Encoding: domain.EncodeOptions{Format: "jpg"}}))
+	assert.Equal("passwd.png", downloadFilename("../../etc/passwd", Variant{Name: DefaultVariant, Encoding: domain.EncodeOptions{Format: "png"}}))
+}
@@ -0,0 +1,36 @@
+package resizer
+
+import (
+	"imageResizerX/domain"
+	"sync"
+)
+
+// DeadLetters keeps the jobs that failed after their last attempt, for an
+// admin to look into. Only the most recent ones are kept, up to capacity.
+type DeadLetters struct {
+	letters  []domain.DeadLetter
+	capacity int
+	lock     sync.Mutex
+}
+
+func NewDeadLetters(capacity int) *DeadLetters {
+	return &DeadLetters{capacity: capacity}
+}
+
+func (d *DeadLetters) Add(letter domain.DeadLetter) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.letters = append(d.letters, letter)
+	if len(d.letters) > d.capacity {
+		d.letters = append([]domain.DeadLetter(nil), d.letters[len(d.letters)-d.capacity:]...)
+	}
+}
+
+// List returns the dead letters, oldest first.
+func (d *DeadLetters) List() []domain.DeadLetter {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]domain.DeadLetter{}, d.letters...)
+}
@@ -0,0 +1,26 @@
+package resizer
+
+import (
+	"imageResizerX/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetters(t *testing.T) {
+	assert := assert.New(t)
+	letters := NewDeadLetters(2)
+
+	assert.Empty(letters.List())
+
+	letters.Add(domain.DeadLetter{JobID: "job-1"})
+	letters.Add(domain.DeadLetter{JobID: "job-2"})
+	letters.Add(domain.DeadLetter{JobID: "job-3"})
+
+	list := letters.List()
+	assert.Equal([]domain.DeadLetter{{JobID: "job-2"}, {JobID: "job-3"}}, list)
+
+	// the list returned is a copy
+	list[0].JobID = "changed"
+	assert.Equal("job-2", letters.List()[0].JobID)
+}
@@ -0,0 +1,152 @@
+package resizer
+
+import (
+	"context"
+	"errors"
+	"imageResizerX/domain"
+	"imageResizerX/logs"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// JobJournal keeps the queued jobs across restarts. Put writes a job down
+// with its source, Update only its state.
+type JobJournal interface {
+	Put(job domain.QueuedJob) error
+	Update(job domain.QueuedJob) error
+	Delete(id string) error
+	List() ([]domain.QueuedJob, error)
+}
+
+// DurableRunner is an ImagePool that writes every job down before queuing
+// it and forgets it once it ran, so the jobs a crash interrupts can be run
+// again on the next start.
+type DurableRunner struct {
+	*ImagePool
+	journal JobJournal
+
+	lock sync.Mutex
+	// retried holds the jobs given to Retry while running, kept written
+	// down once their run returns.
+	retried map[string]struct{}
+}
+
+func NewDurableRunner(pool *ImagePool, journal JobJournal) *DurableRunner {
+	return &DurableRunner{ImagePool: pool, journal: journal, retried: make(map[string]struct{})}
+}
+
+// Submit writes the job of task down before queuing it. Tasks without a
+// job are queued as they are.
+func (d *DurableRunner) Submit(ctx context.Context, task Task) error {
+	if task.Job == nil {
+		return d.ImagePool.Submit(ctx, task)
+	}
+
+	job := *task.Job
+	job.State = domain.QueuedJobWaiting
+	if err := d.journal.Put(job); err != nil {
+		return err
+	}
+
+	err := d.ImagePool.Submit(ctx, d.journaled(ctx, task, job))
+	if err != nil {
+		d.forget(job.ID)
+	}
+	return err
+}
+
+// Recover returns the jobs a previous run left unfinished, oldest first.
+// The ones that were running then are marked retryable, with one more
+// interruption.
+func (d *DurableRunner) Recover() ([]domain.QueuedJob, error) {
+	jobs, err := d.journal.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, job := range jobs {
+		if job.State != domain.QueuedJobRunning {
+			continue
+		}
+
+		job.State = domain.QueuedJobRetryable
+		job.Interruptions++
+		if err := d.journal.Update(job); err != nil {
+			return nil, err
+		}
+		jobs[i] = job
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].QueuedAt.Before(jobs[j].QueuedAt) })
+	return jobs, nil
+}
+
+// Resume queues again a job Recover returned, even when the queue is full.
+func (d *DurableRunner) Resume(ctx context.Context, task Task) error {
+	return d.ImagePool.requeue(ctx, d.journaled(ctx, task, *task.Job))
+}
+
+// Retry queues task again once after passed, its job written down as
+// queued meanwhile so a restart does not lose it.
+func (d *DurableRunner) Retry(ctx context.Context, task Task, after time.Duration) {
+	if task.Job == nil {
+		d.ImagePool.Retry(ctx, task, after)
+		return
+	}
+
+	job := *task.Job
+	job.State = domain.QueuedJobWaiting
+	if err := d.journal.Update(job); err != nil && !errors.Is(err, domain.ErrQueuedJobNotFound) {
+		logs.Logger.Error("Failed to perform job journal update", zap.String("job_id", job.ID), zap.Error(err))
+	}
+
+	d.lock.Lock()
+	d.retried[job.ID] = struct{}{}
+	d.lock.Unlock()
+
+	d.ImagePool.Retry(ctx, d.journaled(ctx, task, job), after)
+}
+
+// Forget drops a job Recover returned that is not resumed.
+func (d *DurableRunner) Forget(id string) {
+	d.forget(id)
+}
+
+// journaled marks job running while task runs and forgets it after, unless
+// it is retried, or once ctx is done and the task is dropped from the queue.
+func (d *DurableRunner) journaled(ctx context.Context, task Task, job domain.QueuedJob) Task {
+	context.AfterFunc(ctx, func() { d.forget(job.ID) })
+
+	run := task.Run
+	task.Run = func(ctx context.Context) {
+		defer func() {
+			d.lock.Lock()
+			_, retried := d.retried[job.ID]
+			delete(d.retried, job.ID)
+			d.lock.Unlock()
+
+			if !retried {
+				d.forget(job.ID)
+			}
+		}()
+
+		job.State = domain.QueuedJobRunning
+		if err := d.journal.Update(job); err != nil && !errors.Is(err, domain.ErrQueuedJobNotFound) {
+			logs.Logger.Error("Failed to perform job journal update", zap.String("job_id", job.ID), zap.Error(err))
+		}
+
+		run(ctx)
+	}
+
+	return task
+}
+
+func (d *DurableRunner) forget(id string) {
+	err := d.journal.Delete(id)
+	if err != nil && !errors.Is(err, domain.ErrQueuedJobNotFound) {
+		logs.Logger.Error("Failed to perform job journal delete", zap.String("job_id", id), zap.Error(err))
+	}
+}
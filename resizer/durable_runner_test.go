@@ -0,0 +1,189 @@
+package resizer
+
+import (
+	"context"
+	"imageResizerX/domain"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryJournal keeps the jobs of a DurableRunner in a map.
+type memoryJournal struct {
+	jobs map[string]domain.QueuedJob
+	lock sync.Mutex
+}
+
+func newMemoryJournal(jobs ...domain.QueuedJob) *memoryJournal {
+	journal := &memoryJournal{jobs: map[string]domain.QueuedJob{}}
+	for _, job := range jobs {
+		journal.jobs[job.ID] = job
+	}
+	return journal
+}
+
+func (j *memoryJournal) Put(job domain.QueuedJob) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.jobs[job.ID] = job
+	return nil
+}
+
+func (j *memoryJournal) Update(job domain.QueuedJob) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if _, ok := j.jobs[job.ID]; !ok {
+		return domain.ErrQueuedJobNotFound
+	}
+	j.jobs[job.ID] = job
+	return nil
+}
+
+func (j *memoryJournal) Delete(id string) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if _, ok := j.jobs[id]; !ok {
+		return domain.ErrQueuedJobNotFound
+	}
+	delete(j.jobs, id)
+	return nil
+}
+
+func (j *memoryJournal) List() ([]domain.QueuedJob, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	jobs := []domain.QueuedJob{}
+	for _, job := range j.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (j *memoryJournal) get(id string) (domain.QueuedJob, bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	job, ok := j.jobs[id]
+	return job, ok
+}
+
+func TestDurableRunnerSubmit(t *testing.T) {
+	assert := assert.New(t)
+	journal := newMemoryJournal()
+	runner := NewDurableRunner(newTestPool(1, 1), journal)
+
+	release := blockWorkers(runner.ImagePool)
+
+	running := make(chan domain.QueuedJob, 1)
+	done := make(chan struct{})
+	task := Task{Client: "alice", Job: &domain.QueuedJob{ID: "a1"}, Run: func(ctx context.Context) {
+		job, _ := journal.get("a1")
+		running <- job
+		close(done)
+	}}
+
+	assert.NoError(runner.Submit(context.Background(), task))
+	job, ok := journal.get("a1")
+	assert.True(ok)
+	assert.Equal(domain.QueuedJobWaiting, job.State)
+
+	// a job the queue refuses is not kept
+	assert.ErrorIs(runner.Submit(context.Background(), Task{Job: &domain.QueuedJob{ID: "b2"}, Run: func(ctx context.Context) {}}), ErrQueueFull)
+	_, ok = journal.get("b2")
+	assert.False(ok)
+
+	release()
+	assert.Equal(domain.QueuedJobRunning, (<-running).State)
+	<-done
+	assert.Eventually(func() bool {
+		_, ok := journal.get("a1")
+		return !ok
+	}, time.Second, time.Millisecond)
+}
+
+func TestDurableRunnerRetry(t *testing.T) {
+	assert := assert.New(t)
+	journal := newMemoryJournal()
+	runner := NewDurableRunner(newTestPool(1, 10), journal)
+
+	attempts := make(chan domain.QueuedJob, 2)
+	attempt := 0
+	var task Task
+	task = Task{Job: &domain.QueuedJob{ID: "a1"}, Run: func(ctx context.Context) {
+		job, _ := journal.get("a1")
+		attempt++
+		if attempt == 1 {
+			runner.Retry(ctx, task, time.Millisecond*50)
+		}
+		attempts <- job
+	}}
+
+	assert.NoError(runner.Submit(context.Background(), task))
+	assert.Equal(domain.QueuedJobRunning, (<-attempts).State)
+
+	// kept written down while waiting for the retry
+	assert.Eventually(func() bool {
+		job, ok := journal.get("a1")
+		return ok && job.State == domain.QueuedJobWaiting
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(domain.QueuedJobRunning, (<-attempts).State)
+	assert.Eventually(func() bool {
+		_, ok := journal.get("a1")
+		return !ok
+	}, time.Second, time.Millisecond)
+}
+
+func TestDurableRunnerCancelled(t *testing.T) {
+	assert := assert.New(t)
+	journal := newMemoryJournal()
+	runner := NewDurableRunner(newTestPool(1, 10), journal)
+
+	release := blockWorkers(runner.ImagePool)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(runner.Submit(ctx, Task{Job: &domain.QueuedJob{ID: "a1"}, Run: func(ctx context.Context) {}}))
+
+	cancel()
+	assert.Eventually(func() bool {
+		_, ok := journal.get("a1")
+		return !ok
+	}, time.Second, time.Millisecond)
+}
+
+func TestDurableRunnerRecover(t *testing.T) {
+	assert := assert.New(t)
+	queuedAt := time.Date(2023, 11, 1, 10, 0, 0, 0, time.UTC)
+	journal := newMemoryJournal(
+		domain.QueuedJob{ID: "waiting", State: domain.QueuedJobWaiting, QueuedAt: queuedAt.Add(time.Minute)},
+		domain.QueuedJob{ID: "running", State: domain.QueuedJobRunning, QueuedAt: queuedAt},
+	)
+	runner := NewDurableRunner(newTestPool(1, 0), journal)
+
+	jobs, err := runner.Recover()
+	assert.NoError(err)
+	assert.Equal([]domain.QueuedJob{
+		{ID: "running", State: domain.QueuedJobRetryable, Interruptions: 1, QueuedAt: queuedAt},
+		{ID: "waiting", State: domain.QueuedJobWaiting, QueuedAt: queuedAt.Add(time.Minute)},
+	}, jobs)
+
+	stored, _ := journal.get("running")
+	assert.Equal(jobs[0], stored)
+
+	// resumed jobs are queued even past the queue size
+	release := blockWorkers(runner.ImagePool)
+	record := &recorder{}
+	for _, job := range jobs {
+		job := job
+		task := record.task(job.ID, PriorityBatch)
+		task.Job = &job
+		assert.NoError(runner.Resume(context.Background(), task))
+	}
+	assert.Equal(2, runner.Stats().Queued)
+
+	release()
+	record.wg.Wait()
+	assert.Equal([]string{"running", "waiting"}, record.order)
+}
@@ -1,29 +1,483 @@
 package resizer
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"imageResizerX/domain"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when a task is submitted to a pool whose
+	// queue has no room left.
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrPoolClosed is returned when a task is submitted to a pool shutting
+	// down.
+	ErrPoolClosed = errors.New("worker pool is shutting down")
+)
+
+// Priority tells how soon a client expects its task to be done.
+type Priority string
+
+const (
+	// PriorityInteractive is for someone waiting on the result.
+	PriorityInteractive Priority = "interactive"
+	// PriorityBatch is for bulk uploads that can wait.
+	PriorityBatch Priority = "batch"
+)
+
+func ParsePriority(value string) (Priority, error) {
+	switch Priority(value) {
+	case "", PriorityInteractive:
+		return PriorityInteractive, nil
+	case PriorityBatch:
+		return PriorityBatch, nil
+	default:
+		return "", fmt.Errorf("priority must be %s or %s, got %q", PriorityInteractive, PriorityBatch, value)
+	}
+}
+
+// Task is a unit of work a client hands to the pool. Run is given the
+// context the task was submitted with, to stop early once it is done. Job,
+// when set, describes the task for a DurableRunner to write it down; the
+// pool itself ignores it.
+type Task struct {
+	Client   string
+	Priority Priority
+	Run      func(ctx context.Context)
+	Job      *domain.QueuedJob
+}
+
+// PoolConfig sizes an ImagePool. A task's share of the workers is the
+// weight of its priority times the weight of its client, both 1 when not
+// configured. ClientMaxWorkers is the most workers a single client may use
+// at once, ClientQueueSize the most tasks of a single client waiting in the
+// queue, no limit when zero.
+type PoolConfig struct {
+	Workers          int
+	QueueSize        int
+	ClientMaxWorkers int
+	ClientQueueSize  int
+	PriorityWeights  map[Priority]int
+	ClientWeights    map[string]int
+}
+
+// PoolStats is a snapshot of the queue and workers of an ImagePool.
+type PoolStats struct {
+	Workers          int              `json:"workers"`
+	BusyWorkers      int              `json:"busy_workers"`
+	Queued           int              `json:"queued"`
+	Retrying         int              `json:"retrying"`
+	QueueSize        int              `json:"queue_size"`
+	QueuedByPriority map[Priority]int `json:"queued_by_priority"`
+}
+
+type flowKey struct {
+	client   string
+	priority Priority
+}
+
+// flow holds the queued tasks of one client at one priority.
+type flow struct {
+	tasks  []queuedTask
+	finish float64
+}
+
+type queuedTask struct {
+	Task
+	ctx   context.Context
+	start float64
+	seq   uint64
+	// stop stops watching the context of the task once it left the queue.
+	stop func() bool
+}
+
+// ImagePool runs tasks on a fixed set of long-lived workers, fed by a queue
+// of bounded size. The queue is served by start-time fair queuing: every
+// flow of tasks of a client at a priority gets the workers in proportion to
+// its weight, so a large batch of one client cannot starve the others.
 type ImagePool struct {
-	worker     chan struct{}
-	maxWorkers int
+	config PoolConfig
+
+	lock   sync.Mutex
+	ready  *sync.Cond
+	flows  map[flowKey]*flow
+	queued int
+	busy   int
+	// retrying counts the tasks waiting to be queued again by Retry.
+	retrying int
+	running  map[string]int
+	// queuedBy counts the queued tasks of every client.
+	queuedBy map[string]int
+	// virtual is the start tag of the task dispatched last.
+	virtual float64
+	seq     uint64
+	// changed is closed and replaced whenever a task leaves the queue or
+	// finishes, to wake the callers of Run waiting for room and Shutdown
+	// waiting for the workers.
+	changed chan struct{}
+	closed  bool
+	// taskTime is a moving average of how long tasks take, to estimate when
+	// a full queue has room again.
+	taskTime time.Duration
 }
 
-func NewImagePool(maxWorkers int) *ImagePool {
-	return &ImagePool{
-		worker:     make(chan struct{}, maxWorkers),
-		maxWorkers: maxWorkers,
+func NewImagePool(config PoolConfig) *ImagePool {
+	pool := &ImagePool{
+		config:   config,
+		flows:    make(map[flowKey]*flow),
+		queuedBy: make(map[string]int),
+		running:  make(map[string]int),
+		changed:  make(chan struct{}),
+		taskTime: time.Second,
+	}
+	pool.ready = sync.NewCond(&pool.lock)
+
+	for i := 0; i < config.Workers; i++ {
+		go pool.work()
 	}
+
+	return pool
 }
 
-func (pool *ImagePool) AcquireWorker() {
-	pool.worker <- struct{}{}
+func (pool *ImagePool) work() {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	for {
+		task, ok := pool.next()
+		if !ok {
+			pool.ready.Wait()
+			continue
+		}
+
+		pool.busy++
+		pool.running[task.Client]++
+		pool.lock.Unlock()
+
+		start := time.Now()
+		task.Run(task.ctx)
+		taskTime := time.Since(start)
+
+		pool.lock.Lock()
+		pool.busy--
+		pool.running[task.Client]--
+		if pool.running[task.Client] == 0 {
+			delete(pool.running, task.Client)
+		}
+		pool.taskTime = (pool.taskTime*7 + taskTime) / 8
+		pool.notify()
+
+		// the client may be allowed another worker now
+		pool.ready.Broadcast()
+	}
 }
 
-func (pool *ImagePool) ReleaseWorker() {
-	<-pool.worker
+// next pops the queued task with the lowest start tag among the clients
+// still allowed a worker.
+func (pool *ImagePool) next() (queuedTask, bool) {
+	var best *flow
+	var bestKey flowKey
+
+	for key, f := range pool.flows {
+		if pool.config.ClientMaxWorkers > 0 && pool.running[key.client] >= pool.config.ClientMaxWorkers {
+			continue
+		}
+
+		head := f.tasks[0]
+		if best == nil || head.start < best.tasks[0].start ||
+			head.start == best.tasks[0].start && head.seq < best.tasks[0].seq {
+			best, bestKey = f, key
+		}
+	}
+
+	if best == nil {
+		return queuedTask{}, false
+	}
+
+	task := best.tasks[0]
+	pool.remove(bestKey, 0)
+	pool.virtual = task.start
+	task.stop()
+	return task, true
+}
+
+// remove takes the task at index i out of the queue of the flow key.
+func (pool *ImagePool) remove(key flowKey, i int) {
+	f := pool.flows[key]
+	f.tasks = append(f.tasks[:i], f.tasks[i+1:]...)
+	if len(f.tasks) == 0 {
+		delete(pool.flows, key)
+	}
+
+	pool.queued--
+	pool.queuedBy[key.client]--
+	if pool.queuedBy[key.client] == 0 {
+		delete(pool.queuedBy, key.client)
+	}
+	pool.notify()
+}
+
+// drop takes the task seq out of the queue when its context is done before
+// a worker took it.
+func (pool *ImagePool) drop(key flowKey, seq uint64) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	f, ok := pool.flows[key]
+	if !ok {
+		return
+	}
+
+	for i, task := range f.tasks {
+		if task.seq == seq {
+			pool.remove(key, i)
+			return
+		}
+	}
+}
+
+func (pool *ImagePool) weight(task Task) float64 {
+	weight := 1
+	if w, ok := pool.config.PriorityWeights[task.Priority]; ok {
+		weight = w
+	}
+	if w, ok := pool.config.ClientWeights[task.Client]; ok {
+		weight *= w
+	}
+	return float64(weight)
+}
+
+// Submit queues task without waiting, or fails with ErrQueueFull. The task
+// is dropped from the queue when ctx is done before a worker takes it.
+func (pool *ImagePool) Submit(ctx context.Context, task Task) error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if pool.closed {
+		return ErrPoolClosed
+	}
+
+	if pool.full(task.Client) {
+		return ErrQueueFull
+	}
+
+	pool.enqueue(ctx, task)
+	return nil
 }
 
-func (pool *ImagePool) RunTask(task func()) {
-	go func() {
-		pool.AcquireWorker()
-		defer pool.ReleaseWorker()
-		task()
-	}()
+// requeue queues task even when the queue is full, for the tasks accepted
+// before a restart.
+func (pool *ImagePool) requeue(ctx context.Context, task Task) error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if pool.closed {
+		return ErrPoolClosed
+	}
+
+	pool.enqueue(ctx, task)
+	return nil
+}
+
+func (pool *ImagePool) notify() {
+	close(pool.changed)
+	pool.changed = make(chan struct{})
+}
+
+// Retry queues task again once after passed, without a worker held
+// meanwhile. The task was admitted already: it is queued past the queue
+// size and while shutting down, and Shutdown waits for it. It is dropped
+// when ctx is done before.
+func (pool *ImagePool) Retry(ctx context.Context, task Task, after time.Duration) {
+	pool.lock.Lock()
+	pool.retrying++
+	pool.lock.Unlock()
+
+	finished := false
+	finish := func(requeue bool) {
+		pool.lock.Lock()
+		defer pool.lock.Unlock()
+
+		if finished {
+			return
+		}
+		finished = true
+
+		pool.retrying--
+		if requeue {
+			pool.enqueue(ctx, task)
+		}
+		pool.notify()
+	}
+
+	timer := time.AfterFunc(after, func() { finish(true) })
+	context.AfterFunc(ctx, func() {
+		timer.Stop()
+		finish(false)
+	})
+}
+
+// Shutdown refuses new tasks with ErrPoolClosed and waits for the queued,
+// retrying and running ones to finish. It gives up with ctx's error when
+// they do not finish in time.
+func (pool *ImagePool) Shutdown(ctx context.Context) error {
+	pool.lock.Lock()
+	pool.closed = true
+
+	for pool.queued > 0 || pool.busy > 0 || pool.retrying > 0 {
+		changed := pool.changed
+		pool.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		pool.lock.Lock()
+	}
+
+	pool.lock.Unlock()
+	return nil
+}
+
+// full tells whether a task of client would be left waiting past the queue
+// size, or past the share of the queue of a client. The tasks about to be
+// taken out of the queue by the idle workers their client may use are not
+// counted as waiting.
+func (pool *ImagePool) full(client string) bool {
+	queued := pool.queuedBy[client] + 1
+
+	if size := pool.config.ClientQueueSize; size > 0 && queued-pool.slots(client) > size {
+		return true
+	}
+
+	dispatchable := min(pool.slots(client), queued)
+	for other, n := range pool.queuedBy {
+		if other != client {
+			dispatchable += min(pool.slots(other), n)
+		}
+	}
+	dispatchable = min(dispatchable, pool.config.Workers-pool.busy)
+
+	return pool.queued+1-dispatchable > pool.config.QueueSize
+}
+
+// slots is how many of the idle workers the tasks of client may take.
+func (pool *ImagePool) slots(client string) int {
+	idle := pool.config.Workers - pool.busy
+	if pool.config.ClientMaxWorkers > 0 {
+		return max(min(idle, pool.config.ClientMaxWorkers-pool.running[client]), 0)
+	}
+	return idle
+}
+
+// enqueue tags task with its start in the virtual time of its flow.
+func (pool *ImagePool) enqueue(ctx context.Context, task Task) {
+	key := flowKey{client: task.Client, priority: task.Priority}
+	f, ok := pool.flows[key]
+	if !ok {
+		f = &flow{finish: pool.virtual}
+		pool.flows[key] = f
+	}
+
+	start := f.finish
+	if start < pool.virtual {
+		start = pool.virtual
+	}
+	f.finish = start + 1/pool.weight(task)
+
+	pool.seq++
+	seq := pool.seq
+	stop := context.AfterFunc(ctx, func() { pool.drop(key, seq) })
+
+	f.tasks = append(f.tasks, queuedTask{Task: task, ctx: ctx, start: start, seq: seq, stop: stop})
+	pool.queued++
+	pool.queuedBy[task.Client]++
+
+	pool.ready.Signal()
+}
+
+// Run queues task and waits for it to finish. It gives up with ctx's error
+// when the queue is full or the task does not finish in time; a task still
+// queued then is dropped, a running one keeps its worker until done.
+func (pool *ImagePool) Run(ctx context.Context, task Task) error {
+	done := make(chan struct{})
+	ran := false
+
+	run := task.Run
+	task.Run = func(ctx context.Context) {
+		defer close(done)
+		if ctx.Err() != nil {
+			return
+		}
+		ran = true
+		run(ctx)
+	}
+
+	for {
+		pool.lock.Lock()
+		if pool.closed {
+			pool.lock.Unlock()
+			return ErrPoolClosed
+		}
+		if !pool.full(task.Client) {
+			pool.enqueue(ctx, task)
+			pool.lock.Unlock()
+			break
+		}
+		changed := pool.changed
+		pool.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	select {
+	case <-done:
+		if !ran {
+			return ctx.Err()
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (pool *ImagePool) Stats() PoolStats {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	byPriority := map[Priority]int{}
+	for key, f := range pool.flows {
+		byPriority[key.priority] += len(f.tasks)
+	}
+
+	return PoolStats{
+		Workers:          pool.config.Workers,
+		BusyWorkers:      pool.busy,
+		Queued:           pool.queued,
+		Retrying:         pool.retrying,
+		QueueSize:        pool.config.QueueSize,
+		QueuedByPriority: byPriority,
+	}
+}
+
+// RetryAfter estimates when the queue has room again: the time the workers
+// take to go through the tasks queued, at least a second.
+func (pool *ImagePool) RetryAfter() time.Duration {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	wait := pool.taskTime * time.Duration(pool.queued) / time.Duration(pool.config.Workers)
+	if wait < time.Second {
+		return time.Second
+	}
+	return wait
 }
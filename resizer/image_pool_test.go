@@ -1,62 +1,333 @@
 package resizer
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestAcquireWorker(t *testing.T) {
+func newTestPool(workers int, queueSize int) *ImagePool {
+	return NewImagePool(PoolConfig{
+		Workers:         workers,
+		QueueSize:       queueSize,
+		PriorityWeights: map[Priority]int{PriorityInteractive: 4, PriorityBatch: 1},
+	})
+}
+
+// blockWorkers keeps every worker of pool busy until the returned function
+// is called.
+func blockWorkers(pool *ImagePool) func() {
+	release := make(chan struct{})
+	var started sync.WaitGroup
+
+	for i := 0; i < pool.config.Workers; i++ {
+		started.Add(1)
+		pool.Submit(context.Background(), Task{Client: fmt.Sprintf("blocker%d", i), Run: func(ctx context.Context) {
+			started.Done()
+			<-release
+		}})
+	}
+
+	started.Wait()
+	return func() { close(release) }
+}
+
+// recorder notes the order tasks ran in.
+type recorder struct {
+	order []string
+	lock  sync.Mutex
+	wg    sync.WaitGroup
+}
+
+func (r *recorder) task(client string, priority Priority) Task {
+	r.wg.Add(1)
+	return Task{Client: client, Priority: priority, Run: func(ctx context.Context) {
+		r.lock.Lock()
+		r.order = append(r.order, client)
+		r.lock.Unlock()
+		r.wg.Done()
+	}}
+}
+
+func TestSubmit(t *testing.T) {
 	assert := assert.New(t)
-	maxWorks := 5
-	pool := NewImagePool(maxWorks)
+	pool := newTestPool(3, 10)
+	record := &recorder{}
 
-	for i := 0; i < maxWorks; i++ {
-		pool.AcquireWorker()
+	for i := 0; i < 10; i++ {
+		assert.NoError(pool.Submit(context.Background(), record.task("alice", PriorityInteractive)))
 	}
 
-	assert.Equal(maxWorks, len(pool.worker))
-	assert.NotPanics(func() {
-		<-pool.worker
-	})
+	record.wg.Wait()
+	assert.Len(record.order, 10)
+}
+
+func TestSubmitQueueFull(t *testing.T) {
+	assert := assert.New(t)
+	pool := newTestPool(2, 1)
+
+	release := blockWorkers(pool)
+	assert.Equal(2, pool.Stats().BusyWorkers)
 
+	assert.NoError(pool.Submit(context.Background(), Task{Priority: PriorityBatch, Run: func(ctx context.Context) {}}))
+	assert.ErrorIs(pool.Submit(context.Background(), Task{Run: func(ctx context.Context) {}}), ErrQueueFull)
+	assert.Equal(PoolStats{
+		Workers:          2,
+		BusyWorkers:      2,
+		Queued:           1,
+		QueueSize:        1,
+		QueuedByPriority: map[Priority]int{PriorityBatch: 1},
+	}, pool.Stats())
+	assert.Equal(time.Second, pool.RetryAfter())
+
+	release()
+	assert.Eventually(func() bool { return pool.Stats().BusyWorkers == 0 }, time.Second, time.Millisecond)
+	assert.NoError(pool.Submit(context.Background(), Task{Run: func(ctx context.Context) {}}))
 }
 
-func TestReleaseWorker(t *testing.T) {
+func TestSubmitClientQueueSize(t *testing.T) {
 	assert := assert.New(t)
-	maxWorks := 3
-	pool := NewImagePool(maxWorks)
+	pool := NewImagePool(PoolConfig{Workers: 1, QueueSize: 8, ClientQueueSize: 2})
+
+	release := blockWorkers(pool)
+	defer release()
 
-	for i := 0; i < maxWorks; i++ {
-		pool.AcquireWorker()
+	task := func(client string) Task {
+		return Task{Client: client, Priority: PriorityBatch, Run: func(ctx context.Context) {}}
 	}
 
-	for i := 0; i < maxWorks; i++ {
-		pool.ReleaseWorker()
+	// the bulk client fills its share, not the whole queue
+	assert.NoError(pool.Submit(context.Background(), task("bulk")))
+	assert.NoError(pool.Submit(context.Background(), task("bulk")))
+	assert.ErrorIs(pool.Submit(context.Background(), task("bulk")), ErrQueueFull)
+
+	assert.NoError(pool.Submit(context.Background(), Task{Client: "alice", Run: func(ctx context.Context) {}}))
+	assert.Equal(3, pool.Stats().Queued)
+}
+
+func TestSubmitClientMaxWorkersQueueFull(t *testing.T) {
+	assert := assert.New(t)
+	pool := NewImagePool(PoolConfig{Workers: 2, QueueSize: 1, ClientMaxWorkers: 1})
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	pool.Submit(context.Background(), Task{Client: "bulk", Run: func(ctx context.Context) {
+		close(started)
+		<-release
+	}})
+	<-started
+
+	// the idle worker is no room for the bulk client, it may not use it
+	assert.NoError(pool.Submit(context.Background(), Task{Client: "bulk", Run: func(ctx context.Context) {}}))
+	assert.ErrorIs(pool.Submit(context.Background(), Task{Client: "bulk", Run: func(ctx context.Context) {}}), ErrQueueFull)
+
+	// another client takes it
+	record := &recorder{}
+	assert.NoError(pool.Submit(context.Background(), record.task("alice", PriorityInteractive)))
+	record.wg.Wait()
+}
+
+func TestSubmitCancelled(t *testing.T) {
+	assert := assert.New(t)
+	pool := newTestPool(1, 10)
+
+	release := blockWorkers(pool)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	assert.NoError(pool.Submit(ctx, Task{Run: func(ctx context.Context) {
+		t.Error("task should not run once cancelled")
+	}}))
+	assert.Equal(1, pool.Stats().Queued)
+
+	cancel()
+	assert.Eventually(func() bool { return pool.Stats().Queued == 0 }, time.Second, time.Millisecond)
+
+	release()
+	record := &recorder{}
+	pool.Submit(context.Background(), record.task("alice", PriorityInteractive))
+	record.wg.Wait()
+}
+
+func TestPoolShutdown(t *testing.T) {
+	assert := assert.New(t)
+	pool := newTestPool(1, 10)
+	record := &recorder{}
+
+	release := blockWorkers(pool)
+	pool.Submit(context.Background(), record.task("alice", PriorityInteractive))
+	pool.Submit(context.Background(), record.task("bob", PriorityBatch))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.ErrorIs(pool.Shutdown(ctx), context.DeadlineExceeded)
+
+	assert.ErrorIs(pool.Submit(context.Background(), Task{Run: func(ctx context.Context) {}}), ErrPoolClosed)
+	assert.ErrorIs(pool.Run(context.Background(), Task{Run: func(ctx context.Context) {}}), ErrPoolClosed)
+
+	// the tasks queued before still run
+	release()
+	assert.NoError(pool.Shutdown(context.Background()))
+	assert.Equal([]string{"alice", "bob"}, record.order)
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	pool := newTestPool(1, 10)
+	pool.taskTime = time.Second * 4
+
+	release := blockWorkers(pool)
+	defer release()
+
+	for i := 0; i < 3; i++ {
+		pool.Submit(context.Background(), Task{Run: func(ctx context.Context) {}})
 	}
 
-	assert.Equal(0, len(pool.worker))
+	assert.Equal(time.Second*12, pool.RetryAfter())
 }
 
-func TestRunTask(t *testing.T) {
+func TestBatchDoesNotStarveInteractive(t *testing.T) {
 	assert := assert.New(t)
-	maxWorks := 3
-	sucessCount := 0
-	pool := NewImagePool(maxWorks)
+	pool := newTestPool(1, 200)
+	record := &recorder{}
+
+	release := blockWorkers(pool)
+	for i := 0; i < 100; i++ {
+		pool.Submit(context.Background(), record.task("bulk", PriorityBatch))
+	}
+	pool.Submit(context.Background(), record.task("alice", PriorityInteractive))
+	pool.Submit(context.Background(), record.task("bob", PriorityInteractive))
+	release()
+
+	record.wg.Wait()
+	// the batch queued first gets its turn, only that one
+	assert.Equal([]string{"bulk", "alice", "bob"}, record.order[:3])
+}
+
+func TestInteractiveBatchesShareWorkers(t *testing.T) {
+	assert := assert.New(t)
+	pool := newTestPool(1, 200)
+	record := &recorder{}
+
+	release := blockWorkers(pool)
+	// the same priority does not let the first client in take every turn
+	for i := 0; i < 50; i++ {
+		pool.Submit(context.Background(), record.task("bulk", PriorityInteractive))
+	}
+	pool.Submit(context.Background(), record.task("alice", PriorityInteractive))
+	release()
+
+	record.wg.Wait()
+	assert.Contains(record.order[:2], "alice")
+}
+
+func TestWeights(t *testing.T) {
+	assert := assert.New(t)
+	pool := NewImagePool(PoolConfig{
+		Workers:       1,
+		QueueSize:     100,
+		ClientWeights: map[string]int{"gold": 3},
+	})
+	record := &recorder{}
+
+	release := blockWorkers(pool)
+	for i := 0; i < 20; i++ {
+		pool.Submit(context.Background(), record.task("gold", PriorityBatch))
+		pool.Submit(context.Background(), record.task("basic", PriorityBatch))
+	}
+	release()
+
+	record.wg.Wait()
+
+	gold := 0
+	for _, client := range record.order[:20] {
+		if client == "gold" {
+			gold++
+		}
+	}
+	assert.Equal(15, gold)
+}
 
-	var wg sync.WaitGroup
+func TestClientMaxWorkers(t *testing.T) {
+	assert := assert.New(t)
+	pool := NewImagePool(PoolConfig{Workers: 2, QueueSize: 10, ClientMaxWorkers: 1})
 
-	for i := 0; i < maxWorks; i++ {
-		wg.Add(1)
-		pool.RunTask(func() {
-			sucessCount++
-			wg.Done()
-		})
+	release := make(chan struct{})
+	started := make(chan string, 3)
+	for _, client := range []string{"bulk", "bulk", "alice"} {
+		client := client
+		pool.Submit(context.Background(), Task{Client: client, Run: func(ctx context.Context) {
+			started <- client
+			<-release
+		}})
 	}
 
-	wg.Wait()
+	assert.ElementsMatch([]string{"bulk", "alice"}, []string{<-started, <-started})
+	assert.Equal(1, pool.Stats().Queued)
+
+	close(release)
+	assert.Equal("bulk", <-started)
+}
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+	pool := newTestPool(1, 1)
+
+	ran := false
+	err := pool.Run(context.Background(), Task{Run: func(ctx context.Context) {
+		ran = true
+	}})
+
+	assert.NoError(err)
+	assert.True(ran)
+
+	release := blockWorkers(pool)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	err = pool.Run(ctx, Task{Run: func(ctx context.Context) {
+		t.Error("task should not run once its context is done")
+	}})
+
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	release()
+
+	assert.Eventually(func() bool { return pool.Stats().Queued == 0 }, time.Second, time.Millisecond)
+	assert.NoError(pool.Run(context.Background(), Task{Run: func(ctx context.Context) {}}))
+}
+
+func TestRetry(t *testing.T) {
+	assert := assert.New(t)
+	pool := newTestPool(1, 0)
+	record := &recorder{}
+
+	pool.Retry(context.Background(), record.task("alice", PriorityInteractive), time.Millisecond*50)
+	assert.Equal(1, pool.Stats().Retrying)
+
+	// the worker is free meanwhile
+	assert.NoError(pool.Run(context.Background(), Task{Run: func(ctx context.Context) {}}))
+
+	// shutting down waits for the retry
+	assert.NoError(pool.Shutdown(context.Background()))
+	assert.Equal([]string{"alice"}, record.order)
+	assert.Equal(0, pool.Stats().Retrying)
+}
+
+func TestRetryCancelled(t *testing.T) {
+	assert := assert.New(t)
+	pool := newTestPool(1, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pool.Retry(ctx, Task{Run: func(ctx context.Context) {
+		t.Error("task should not run once cancelled")
+	}}, time.Millisecond*20)
 
-	assert.Equal(maxWorks, sucessCount)
-	assert.Equal(0, len(pool.worker))
+	cancel()
+	assert.Eventually(func() bool { return pool.Stats().Retrying == 0 }, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 40)
+	assert.Equal(0, pool.Stats().Queued)
 }
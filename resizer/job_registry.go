@@ -0,0 +1,176 @@
+package resizer
+
+import (
+	"context"
+	"errors"
+	"imageResizerX/domain"
+	"sync"
+	"time"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+)
+
+type JobRegistry struct {
+	jobs map[string]*domain.Job
+	// cancels stops the jobs not finished yet
+	cancels   map[string]context.CancelFunc
+	lock      sync.RWMutex
+	retention time.Duration
+	now       func() time.Time
+}
+
+func NewJobRegistry() *JobRegistry {
+	return &JobRegistry{
+		jobs:      make(map[string]*domain.Job),
+		cancels:   make(map[string]context.CancelFunc),
+		retention: time.Hour,
+		now:       time.Now,
+	}
+}
+
+// Create registers a queued job. The returned context is done once the job
+// is cancelled.
+func (r *JobRegistry) Create(id string, variants []domain.JobVariant) (domain.Job, context.Context) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.forgetOld()
+
+	job := &domain.Job{ID: id, State: domain.JobQueued, CreatedAt: r.now(), Variants: variants}
+	r.jobs[id] = job
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancels[id] = cancel
+
+	return *job, ctx
+}
+
+// Start marks the job as processing, once per attempt.
+func (r *JobRegistry) Start(id string) {
+	r.update(id, func(job *domain.Job) {
+		if job.State != domain.JobQueued && job.State != domain.JobProcessing {
+			return
+		}
+
+		if job.StartedAt == nil {
+			now := r.now()
+			job.StartedAt = &now
+		}
+		job.State = domain.JobProcessing
+		job.Attempts++
+	})
+}
+
+// Complete marks the job as done with the stored variants, downloadable
+// until expiresAt or for ever when it is nil. The first variant is the
+// job's main download. It tells whether the job was completed, not when it
+// was cancelled before.
+func (r *JobRegistry) Complete(id string, variants []domain.JobVariant, expiresAt *time.Time) bool {
+	return r.finish(id, func(job *domain.Job) {
+		now := r.now()
+		job.State = domain.JobComplete
+		job.FinishedAt = &now
+		job.ExpiresAt = expiresAt
+		job.Variants = variants
+		if len(variants) > 0 {
+			job.DownloadUrl = variants[0].DownloadUrl
+		}
+	})
+}
+
+// Fail tells whether the job was failed, not when it was cancelled before.
+func (r *JobRegistry) Fail(id string, reason string) bool {
+	return r.finish(id, func(job *domain.Job) {
+		now := r.now()
+		job.State = domain.JobFailed
+		job.FinishedAt = &now
+		job.Error = reason
+	})
+}
+
+// Cancel stops a job not finished yet, its context is done right away.
+func (r *JobRegistry) Cancel(id string) (domain.Job, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return domain.Job{}, ErrJobNotFound
+	}
+
+	cancel, ok := r.cancels[id]
+	if !ok {
+		return *job, ErrJobFinished
+	}
+
+	now := r.now()
+	job.State = domain.JobCancelled
+	job.FinishedAt = &now
+	r.release(id, cancel)
+
+	return *job, nil
+}
+
+func (r *JobRegistry) Get(id string) (domain.Job, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return domain.Job{}, false
+	}
+
+	job.Expire(r.now())
+
+	copied := *job
+	copied.Variants = append([]domain.JobVariant(nil), job.Variants...)
+	return copied, true
+}
+
+func (r *JobRegistry) update(id string, fn func(job *domain.Job)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return
+	}
+
+	fn(job)
+}
+
+// finish updates a job not finished yet, a cancelled job is left as is.
+func (r *JobRegistry) finish(id string, fn func(job *domain.Job)) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	cancel, ok := r.cancels[id]
+	if !ok {
+		return false
+	}
+
+	fn(r.jobs[id])
+	r.release(id, cancel)
+	return true
+}
+
+// release frees the context of a finished job.
+func (r *JobRegistry) release(id string, cancel context.CancelFunc) {
+	cancel()
+	delete(r.cancels, id)
+}
+
+// forgetOld drops finished jobs once they are older than the retention, so
+// the registry does not grow for ever.
+func (r *JobRegistry) forgetOld() {
+	now := r.now()
+
+	for id, job := range r.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > r.retention {
+			delete(r.jobs, id)
+		}
+	}
+}
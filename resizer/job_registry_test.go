@@ -0,0 +1,123 @@
+package resizer
+
+import (
+	"context"
+	"imageResizerX/domain"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobRegistryLifecycle(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2023, 11, 1, 10, 0, 0, 0, time.UTC)
+	registry := NewJobRegistry()
+	registry.now = func() time.Time { return now }
+
+	job, _ := registry.Create("job-1", []domain.JobVariant{
+		{Name: "thumb", Filter: "box"},
+		{Name: "large", Filter: "lanczos"},
+	})
+	assert.Equal(domain.JobQueued, job.State)
+
+	registry.Start("job-1")
+	job, _ = registry.Get("job-1")
+	assert.Equal(domain.JobProcessing, job.State)
+	assert.NotNil(job.StartedAt)
+	assert.Equal(1, job.Attempts)
+
+	// retried
+	startedAt := *job.StartedAt
+	now = now.Add(time.Second)
+	registry.Start("job-1")
+	job, _ = registry.Get("job-1")
+	assert.Equal(2, job.Attempts)
+	assert.Equal(startedAt, *job.StartedAt)
+
+	expiresAt := now.Add(domain.ImageLifetime)
+	registry.Complete("job-1", []domain.JobVariant{
+		{Name: "thumb", Filter: "box", DownloadUrl: "/api/v1/download/out_thumb.png"},
+		{Name: "large", Filter: "lanczos", DownloadUrl: "/api/v1/download/out_large.png"},
+	}, &expiresAt)
+	job, _ = registry.Get("job-1")
+	assert.Equal(domain.JobComplete, job.State)
+	assert.Equal("/api/v1/download/out_thumb.png", job.DownloadUrl)
+	assert.Len(job.Variants, 2)
+
+	now = now.Add(domain.ImageLifetime + time.Second)
+	job, _ = registry.Get("job-1")
+	assert.Equal(domain.JobExpired, job.State)
+	assert.Empty(job.DownloadUrl)
+	assert.Empty(job.Variants[0].DownloadUrl)
+	assert.Equal("box", job.Variants[0].Filter)
+}
+
+func TestJobRegistryFail(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := NewJobRegistry()
+	registry.Create("job-1", nil)
+	registry.Fail("job-1", "decode failed")
+
+	job, ok := registry.Get("job-1")
+	assert.True(ok)
+	assert.Equal(domain.JobFailed, job.State)
+	assert.Equal("decode failed", job.Error)
+	assert.NotNil(job.FinishedAt)
+
+	_, ok = registry.Get("unknown")
+	assert.False(ok)
+}
+
+func TestJobRegistryCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := NewJobRegistry()
+	_, ctx := registry.Create("job-1", nil)
+	registry.Start("job-1")
+
+	job, err := registry.Cancel("job-1")
+	assert.NoError(err)
+	assert.Equal(domain.JobCancelled, job.State)
+	assert.NotNil(job.FinishedAt)
+	assert.ErrorIs(ctx.Err(), context.Canceled)
+
+	// the worker noticing late does not overwrite the cancellation
+	assert.False(registry.Fail("job-1", context.Canceled.Error()))
+	assert.False(registry.Complete("job-1", nil, nil))
+	job, _ = registry.Get("job-1")
+	assert.Equal(domain.JobCancelled, job.State)
+	assert.Empty(job.Error)
+
+	_, err = registry.Cancel("job-1")
+	assert.ErrorIs(err, ErrJobFinished)
+
+	registry.Create("job-2", nil)
+	registry.Complete("job-2", nil, nil)
+	_, err = registry.Cancel("job-2")
+	assert.ErrorIs(err, ErrJobFinished)
+	job, _ = registry.Get("job-2")
+	assert.Equal(domain.JobComplete, job.State)
+
+	_, err = registry.Cancel("unknown")
+	assert.ErrorIs(err, ErrJobNotFound)
+}
+
+func TestJobRegistryForgetsOldJobs(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	registry := NewJobRegistry()
+	registry.now = func() time.Time { return now }
+
+	registry.Create("job-1", nil)
+	registry.Fail("job-1", "failed")
+
+	now = now.Add(registry.retention + time.Second)
+	registry.Create("job-2", nil)
+
+	_, ok := registry.Get("job-1")
+	assert.False(ok)
+}
@@ -0,0 +1,144 @@
+package resizer
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	DefaultWidth  = 300
+	DefaultHeight = 200
+	MaxDimension  = 8192
+)
+
+type FitMode string
+
+const (
+	FitStretch FitMode = "stretch"
+	FitInside  FitMode = "fit"
+	FitFill    FitMode = "fill"
+	FitPad     FitMode = "pad"
+)
+
+var fitModeAliases = map[string]FitMode{
+	"":              FitStretch,
+	"stretch":       FitStretch,
+	"fit":           FitInside,
+	"fit-inside":    FitInside,
+	"fill":          FitFill,
+	"fill-and-crop": FitFill,
+	"pad":           FitPad,
+	"pad-to-canvas": FitPad,
+}
+
+func ParseFitMode(mode string) (FitMode, error) {
+	fit, ok := fitModeAliases[mode]
+	if !ok {
+		return "", fmt.Errorf("unknown fit mode %q", mode)
+	}
+	return fit, nil
+}
+
+const DefaultFilter = "lanczos"
+
+var filters = map[string]imaging.ResampleFilter{
+	"nearest":           imaging.NearestNeighbor,
+	"box":               imaging.Box,
+	"linear":            imaging.Linear,
+	"hermite":           imaging.Hermite,
+	"mitchellnetravali": imaging.MitchellNetravali,
+	"catmullrom":        imaging.CatmullRom,
+	"bspline":           imaging.BSpline,
+	"gaussian":          imaging.Gaussian,
+	"bartlett":          imaging.Bartlett,
+	"lanczos":           imaging.Lanczos,
+	"hann":              imaging.Hann,
+	"hamming":           imaging.Hamming,
+	"blackman":          imaging.Blackman,
+	"welch":             imaging.Welch,
+	"cosine":            imaging.Cosine,
+}
+
+// ParseFilter checks filter against the resampling filters of imaging and
+// returns its canonical name, lanczos when empty.
+func ParseFilter(filter string) (string, error) {
+	if filter == "" {
+		return DefaultFilter, nil
+	}
+
+	filter = strings.ToLower(filter)
+	if _, ok := filters[filter]; !ok {
+		return "", fmt.Errorf("unknown resampling filter %q", filter)
+	}
+	return filter, nil
+}
+
+type ResizeOptions struct {
+	Width  int
+	Height int
+	Fit    FitMode
+	Filter string
+}
+
+func DefaultResizeOptions() ResizeOptions {
+	return ResizeOptions{Width: DefaultWidth, Height: DefaultHeight, Fit: FitStretch, Filter: DefaultFilter}
+}
+
+func (o ResizeOptions) Validate() error {
+	if o.Width < 0 || o.Height < 0 {
+		return errors.New("width and height must not be negative")
+	}
+
+	if o.Width == 0 && o.Height == 0 {
+		return errors.New("at least one of width or height must be given")
+	}
+
+	if o.Width > MaxDimension || o.Height > MaxDimension {
+		return fmt.Errorf("width and height must not exceed %d", MaxDimension)
+	}
+
+	if _, err := ParseFitMode(string(o.Fit)); err != nil {
+		return err
+	}
+
+	if _, err := ParseFilter(o.Filter); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o ResizeOptions) filter() imaging.ResampleFilter {
+	filter, ok := filters[o.Filter]
+	if !ok {
+		return imaging.Lanczos
+	}
+	return filter
+}
+
+// Apply resizes img according to the options. When only one dimension is
+// given the aspect ratio is preserved and the fit mode is irrelevant.
+func (o ResizeOptions) Apply(img image.Image) *image.NRGBA {
+	filter := o.filter()
+
+	if o.Width == 0 || o.Height == 0 {
+		return imaging.Resize(img, o.Width, o.Height, filter)
+	}
+
+	switch o.Fit {
+	case FitInside:
+		return imaging.Fit(img, o.Width, o.Height, filter)
+	case FitFill:
+		return imaging.Fill(img, o.Width, o.Height, imaging.Center, filter)
+	case FitPad:
+		canvas := imaging.New(o.Width, o.Height, color.Transparent)
+		return imaging.PasteCenter(canvas, imaging.Fit(img, o.Width, o.Height, filter))
+	default:
+		return imaging.Resize(img, o.Width, o.Height, filter)
+	}
+}
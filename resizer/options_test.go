@@ -0,0 +1,127 @@
+package resizer
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFitMode(t *testing.T) {
+	assert := assert.New(t)
+
+	type testCase struct {
+		mode         string
+		expectResult FitMode
+		expectError  bool
+	}
+
+	for _, scenario := range []testCase{
+		{mode: "", expectResult: FitStretch},
+		{mode: "stretch", expectResult: FitStretch},
+		{mode: "fit-inside", expectResult: FitInside},
+		{mode: "fill-and-crop", expectResult: FitFill},
+		{mode: "pad-to-canvas", expectResult: FitPad},
+		{mode: "zoom", expectError: true},
+	} {
+		t.Run(scenario.mode, func(t *testing.T) {
+			result, err := ParseFitMode(scenario.mode)
+
+			if scenario.expectError {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(scenario.expectResult, result)
+		})
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	type testCase struct {
+		filter       string
+		expectResult string
+		expectError  bool
+	}
+
+	for _, scenario := range []testCase{
+		{filter: "", expectResult: DefaultFilter},
+		{filter: "box", expectResult: "box"},
+		{filter: "NEAREST", expectResult: "nearest"},
+		{filter: "catmullrom", expectResult: "catmullrom"},
+		{filter: "sinc", expectError: true},
+	} {
+		t.Run(scenario.filter, func(t *testing.T) {
+			result, err := ParseFilter(scenario.filter)
+
+			if scenario.expectError {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(scenario.expectResult, result)
+		})
+	}
+}
+
+func TestResizeOptionsValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	type testCase struct {
+		name        string
+		opts        ResizeOptions
+		expectError bool
+	}
+
+	for _, scenario := range []testCase{
+		{name: "both dimensions", opts: ResizeOptions{Width: 100, Height: 50, Fit: FitFill}},
+		{name: "width only", opts: ResizeOptions{Width: 100}},
+		{name: "height only", opts: ResizeOptions{Height: 100}},
+		{name: "no dimensions", opts: ResizeOptions{}, expectError: true},
+		{name: "negative", opts: ResizeOptions{Width: -1, Height: 10}, expectError: true},
+		{name: "too large", opts: ResizeOptions{Width: MaxDimension + 1}, expectError: true},
+		{name: "unknown fit", opts: ResizeOptions{Width: 10, Fit: "zoom"}, expectError: true},
+		{name: "unknown filter", opts: ResizeOptions{Width: 10, Filter: "sinc"}, expectError: true},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			err := scenario.opts.Validate()
+
+			if scenario.expectError {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestResizeOptionsApply(t *testing.T) {
+	assert := assert.New(t)
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+
+	type testCase struct {
+		name         string
+		opts         ResizeOptions
+		expectResult image.Point
+	}
+
+	for _, scenario := range []testCase{
+		{name: "stretch", opts: ResizeOptions{Width: 100, Height: 100, Fit: FitStretch}, expectResult: image.Pt(100, 100)},
+		{name: "fit", opts: ResizeOptions{Width: 100, Height: 100, Fit: FitInside}, expectResult: image.Pt(100, 50)},
+		{name: "fill", opts: ResizeOptions{Width: 100, Height: 100, Fit: FitFill}, expectResult: image.Pt(100, 100)},
+		{name: "pad", opts: ResizeOptions{Width: 100, Height: 100, Fit: FitPad}, expectResult: image.Pt(100, 100)},
+		{name: "width only keeps ratio", opts: ResizeOptions{Width: 100, Fit: FitFill}, expectResult: image.Pt(100, 50)},
+		{name: "height only keeps ratio", opts: ResizeOptions{Height: 100}, expectResult: image.Pt(200, 100)},
+		{name: "nearest filter", opts: ResizeOptions{Width: 40, Height: 20, Filter: "nearest"}, expectResult: image.Pt(40, 20)},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			result := scenario.opts.Apply(src)
+			assert.Equal(scenario.expectResult, result.Bounds().Size())
+		})
+	}
+}
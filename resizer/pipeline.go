@@ -0,0 +1,229 @@
+package resizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const MaxOperations = 20
+
+const (
+	OpCrop       = "crop"
+	OpRotate     = "rotate"
+	OpFlip       = "flip"
+	OpBlur       = "blur"
+	OpSharpen    = "sharpen"
+	OpBrightness = "brightness"
+	OpContrast   = "contrast"
+	OpGamma      = "gamma"
+	OpSaturation = "saturation"
+	OpGrayscale  = "grayscale"
+	OpInvert     = "invert"
+)
+
+var anchors = map[string]imaging.Anchor{
+	"center":      imaging.Center,
+	"topleft":     imaging.TopLeft,
+	"top":         imaging.Top,
+	"topright":    imaging.TopRight,
+	"left":        imaging.Left,
+	"right":       imaging.Right,
+	"bottomleft":  imaging.BottomLeft,
+	"bottom":      imaging.Bottom,
+	"bottomright": imaging.BottomRight,
+}
+
+// Operation is one step of a Pipeline. Op selects the transformation and
+// only the fields it uses are read:
+//
+//	crop                      x, y, width, height, or width, height and anchor
+//	rotate                    angle in degrees, counter-clockwise
+//	flip                      direction, horizontal or vertical
+//	blur, sharpen             sigma
+//	brightness, contrast      percentage from -100 to 100
+//	saturation                percentage from -100 to 500
+//	gamma                     gamma, above 0
+//	grayscale, invert         nothing
+type Operation struct {
+	Op         string  `json:"op"`
+	X          int     `json:"x,omitempty"`
+	Y          int     `json:"y,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	Anchor     string  `json:"anchor,omitempty"`
+	Angle      float64 `json:"angle,omitempty"`
+	Direction  string  `json:"direction,omitempty"`
+	Sigma      float64 `json:"sigma,omitempty"`
+	Percentage float64 `json:"percentage,omitempty"`
+	Gamma      float64 `json:"gamma,omitempty"`
+}
+
+func (o Operation) Validate() error {
+	switch o.Op {
+	case OpCrop:
+		if o.Width <= 0 || o.Height <= 0 || o.Width > MaxDimension || o.Height > MaxDimension {
+			return fmt.Errorf("crop width and height must be between 1 and %d", MaxDimension)
+		}
+		if o.Anchor != "" {
+			if _, ok := anchors[o.Anchor]; !ok {
+				return fmt.Errorf("unknown crop anchor %q", o.Anchor)
+			}
+		}
+		if o.X < 0 || o.Y < 0 {
+			return errors.New("crop x and y must not be negative")
+		}
+	case OpRotate:
+		if math.IsNaN(o.Angle) || math.IsInf(o.Angle, 0) {
+			return errors.New("rotate angle must be a number")
+		}
+	case OpFlip:
+		if o.Direction != "horizontal" && o.Direction != "vertical" {
+			return fmt.Errorf("flip direction must be horizontal or vertical, got %q", o.Direction)
+		}
+	case OpBlur, OpSharpen:
+		if o.Sigma <= 0 || o.Sigma > 50 {
+			return fmt.Errorf("%s sigma must be above 0 and at most 50", o.Op)
+		}
+	case OpBrightness, OpContrast:
+		if o.Percentage < -100 || o.Percentage > 100 {
+			return fmt.Errorf("%s percentage must be between -100 and 100", o.Op)
+		}
+	case OpSaturation:
+		if o.Percentage < -100 || o.Percentage > 500 {
+			return errors.New("saturation percentage must be between -100 and 500")
+		}
+	case OpGamma:
+		if o.Gamma <= 0 || o.Gamma > 10 {
+			return errors.New("gamma must be above 0 and at most 10")
+		}
+	case OpGrayscale, OpInvert:
+	default:
+		return fmt.Errorf("unknown operation %q", o.Op)
+	}
+
+	return nil
+}
+
+func (o Operation) Apply(img image.Image) (*image.NRGBA, error) {
+	switch o.Op {
+	case OpCrop:
+		var cropped *image.NRGBA
+		if o.Anchor != "" {
+			cropped = imaging.CropAnchor(img, o.Width, o.Height, anchors[o.Anchor])
+		} else {
+			min := img.Bounds().Min
+			cropped = imaging.Crop(img, image.Rect(o.X, o.Y, o.X+o.Width, o.Y+o.Height).Add(min))
+		}
+		if cropped.Bounds().Empty() {
+			return nil, errors.New("crop rectangle is outside of the image")
+		}
+		return cropped, nil
+	case OpRotate:
+		return rotate(img, o.Angle), nil
+	case OpFlip:
+		if o.Direction == "vertical" {
+			return imaging.FlipV(img), nil
+		}
+		return imaging.FlipH(img), nil
+	case OpBlur:
+		return imaging.Blur(img, o.Sigma), nil
+	case OpSharpen:
+		return imaging.Sharpen(img, o.Sigma), nil
+	case OpBrightness:
+		return imaging.AdjustBrightness(img, o.Percentage), nil
+	case OpContrast:
+		return imaging.AdjustContrast(img, o.Percentage), nil
+	case OpSaturation:
+		return imaging.AdjustSaturation(img, o.Percentage), nil
+	case OpGamma:
+		return imaging.AdjustGamma(img, o.Gamma), nil
+	case OpGrayscale:
+		return imaging.Grayscale(img), nil
+	case OpInvert:
+		return imaging.Invert(img), nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", o.Op)
+	}
+}
+
+// rotate uses the lossless transforms for right angles and interpolates
+// with a transparent background otherwise.
+func rotate(img image.Image, angle float64) *image.NRGBA {
+	switch math.Mod(math.Mod(angle, 360)+360, 360) {
+	case 0:
+		return imaging.Clone(img)
+	case 90:
+		return imaging.Rotate90(img)
+	case 180:
+		return imaging.Rotate180(img)
+	case 270:
+		return imaging.Rotate270(img)
+	default:
+		return imaging.Rotate(img, angle, color.Transparent)
+	}
+}
+
+// orient turns the image upright from its EXIF orientation, the way viewers
+// would display it.
+func orient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	default:
+		return img
+	}
+}
+
+// Pipeline is the ordered list of operations run on a decoded image before
+// its variants are resized.
+type Pipeline []Operation
+
+func (p Pipeline) Validate() error {
+	if len(p) > MaxOperations {
+		return fmt.Errorf("at most %d operations can be given", MaxOperations)
+	}
+
+	for i, op := range p {
+		if err := op.Validate(); err != nil {
+			return fmt.Errorf("operation %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// Apply runs the operations in order, unless ctx is done before one of
+// them.
+func (p Pipeline) Apply(ctx context.Context, img image.Image) (image.Image, error) {
+	for i, op := range p {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		out, err := op.Apply(img)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i+1, err)
+		}
+		img = out
+	}
+
+	return img, nil
+}
@@ -0,0 +1,138 @@
+package resizer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	type testCase struct {
+		name        string
+		op          Operation
+		expectError bool
+	}
+
+	for _, scenario := range []testCase{
+		{name: "crop rect", op: Operation{Op: OpCrop, X: 10, Y: 10, Width: 20, Height: 20}},
+		{name: "crop anchor", op: Operation{Op: OpCrop, Width: 20, Height: 20, Anchor: "bottomright"}},
+		{name: "crop without size", op: Operation{Op: OpCrop, X: 10}, expectError: true},
+		{name: "crop bad anchor", op: Operation{Op: OpCrop, Width: 20, Height: 20, Anchor: "middle"}, expectError: true},
+		{name: "rotate", op: Operation{Op: OpRotate, Angle: 33.5}},
+		{name: "flip", op: Operation{Op: OpFlip, Direction: "vertical"}},
+		{name: "flip bad direction", op: Operation{Op: OpFlip, Direction: "diagonal"}, expectError: true},
+		{name: "blur", op: Operation{Op: OpBlur, Sigma: 1.5}},
+		{name: "blur without sigma", op: Operation{Op: OpBlur}, expectError: true},
+		{name: "sharpen too strong", op: Operation{Op: OpSharpen, Sigma: 100}, expectError: true},
+		{name: "brightness", op: Operation{Op: OpBrightness, Percentage: -20}},
+		{name: "contrast out of range", op: Operation{Op: OpContrast, Percentage: 150}, expectError: true},
+		{name: "saturation", op: Operation{Op: OpSaturation, Percentage: 150}},
+		{name: "gamma", op: Operation{Op: OpGamma, Gamma: 0.8}},
+		{name: "gamma zero", op: Operation{Op: OpGamma}, expectError: true},
+		{name: "grayscale", op: Operation{Op: OpGrayscale}},
+		{name: "invert", op: Operation{Op: OpInvert}},
+		{name: "unknown", op: Operation{Op: "emboss"}, expectError: true},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			err := scenario.op.Validate()
+
+			if scenario.expectError {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestPipelineApply(t *testing.T) {
+	assert := assert.New(t)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	src.Set(0, 0, color.NRGBA{R: 255, A: 255})
+
+	type testCase struct {
+		name         string
+		pipeline     Pipeline
+		expectResult image.Point
+	}
+
+	for _, scenario := range []testCase{
+		{name: "empty", pipeline: Pipeline{}, expectResult: image.Pt(40, 20)},
+		{name: "crop rect", pipeline: Pipeline{{Op: OpCrop, X: 5, Y: 5, Width: 10, Height: 10}}, expectResult: image.Pt(10, 10)},
+		{name: "crop clipped", pipeline: Pipeline{{Op: OpCrop, X: 30, Y: 0, Width: 20, Height: 20}}, expectResult: image.Pt(10, 20)},
+		{name: "rotate 90", pipeline: Pipeline{{Op: OpRotate, Angle: 90}}, expectResult: image.Pt(20, 40)},
+		{name: "rotate -270", pipeline: Pipeline{{Op: OpRotate, Angle: -270}}, expectResult: image.Pt(20, 40)},
+		{name: "rotate 180", pipeline: Pipeline{{Op: OpRotate, Angle: 180}}, expectResult: image.Pt(40, 20)},
+		{name: "in order", pipeline: Pipeline{{Op: OpRotate, Angle: 270}, {Op: OpCrop, Width: 20, Height: 10, Anchor: "top"}}, expectResult: image.Pt(20, 10)},
+		{name: "adjustments", pipeline: Pipeline{{Op: OpGrayscale}, {Op: OpInvert}, {Op: OpBlur, Sigma: 1}, {Op: OpGamma, Gamma: 1.2}}, expectResult: image.Pt(40, 20)},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			result, err := scenario.pipeline.Apply(context.Background(), src)
+			assert.NoError(err)
+			assert.Equal(scenario.expectResult, result.Bounds().Size())
+		})
+	}
+}
+
+func TestPipelineApplyFlip(t *testing.T) {
+	assert := assert.New(t)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	src.Set(0, 0, color.NRGBA{R: 255, A: 255})
+
+	result, err := Pipeline{{Op: OpFlip, Direction: "horizontal"}}.Apply(context.Background(), src)
+	assert.NoError(err)
+	assert.Equal(color.NRGBA{R: 255, A: 255}, result.(*image.NRGBA).NRGBAAt(3, 0))
+}
+
+func TestPipelineApplyCropOutside(t *testing.T) {
+	assert := assert.New(t)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	_, err := Pipeline{{Op: OpCrop, X: 100, Y: 100, Width: 10, Height: 10}}.Apply(context.Background(), src)
+
+	assert.Error(err)
+}
+
+// checkedContext is cancelled once its error was checked a number of times.
+type checkedContext struct {
+	context.Context
+	checks int
+}
+
+func (c *checkedContext) Err() error {
+	if c.checks == 0 {
+		return context.Canceled
+	}
+	c.checks--
+	return nil
+}
+
+func TestPipelineApplyCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	pipeline := Pipeline{{Op: OpGrayscale}, {Op: OpBlur, Sigma: 50}, {Op: OpRotate, Angle: 33}}
+
+	// cancelled while the first operation runs
+	ctx := &checkedContext{Context: context.Background(), checks: 1}
+	_, err := pipeline.Apply(ctx, src)
+
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(0, ctx.checks)
+}
+
+func TestPipelineValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(Pipeline{{Op: OpGrayscale}}.Validate())
+	assert.Error(Pipeline{{Op: OpGrayscale}, {Op: OpBlur}}.Validate())
+	assert.Error(make(Pipeline, MaxOperations+1).Validate())
+}
@@ -0,0 +1,100 @@
+package resizer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imageResizerX/codec"
+	"imageResizerX/domain"
+	"io/fs"
+	"os"
+)
+
+// Preset is a named set of resize and encode parameters defined by the
+// operator. An empty Format keeps the output format of the upload.
+type Preset struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Fit         string `json:"fit,omitempty"`
+	Filter      string `json:"filter,omitempty"`
+	Format      string `json:"format,omitempty"`
+	Quality     int    `json:"quality,omitempty"`
+	Compression string `json:"compression,omitempty"`
+}
+
+// Variant turns the preset into a variant for an image of inputFormat.
+func (p Preset) Variant(name string, inputFormat string) (Variant, error) {
+	fit, err := ParseFitMode(p.Fit)
+	if err != nil {
+		return Variant{}, err
+	}
+
+	filter, err := ParseFilter(p.Filter)
+	if err != nil {
+		return Variant{}, err
+	}
+
+	format := p.Format
+	if format == "" {
+		format = codec.OutputFormat(inputFormat)
+	}
+
+	return Variant{
+		Name:   name,
+		Resize: ResizeOptions{Width: p.Width, Height: p.Height, Fit: fit, Filter: filter},
+		Encoding: domain.EncodeOptions{
+			Format:      codec.NormalizeFormat(format),
+			Quality:     p.Quality,
+			Compression: p.Compression,
+		},
+	}, nil
+}
+
+type Presets struct {
+	presets map[string]Preset
+}
+
+func NewPresets(presets map[string]Preset) (*Presets, error) {
+	for name, preset := range presets {
+		variant, err := preset.Variant(name, "png")
+		if err == nil {
+			err = ValidateVariants([]Variant{variant})
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("preset %s: %w", name, err)
+		}
+	}
+
+	return &Presets{presets: presets}, nil
+}
+
+// LoadPresets reads the presets from a JSON file mapping preset names to
+// their parameters. A missing file means no presets.
+func LoadPresets(path string) (*Presets, error) {
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewPresets(map[string]Preset{})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	presets := map[string]Preset{}
+	if err := json.Unmarshal(data, &presets); err != nil {
+		return nil, fmt.Errorf("invalid presets file %s: %w", path, err)
+	}
+
+	return NewPresets(presets)
+}
+
+func (p *Presets) Get(name string) (Preset, bool) {
+	preset, ok := p.presets[name]
+	return preset, ok
+}
+
+func (p *Presets) All() map[string]Preset {
+	return p.presets
+}
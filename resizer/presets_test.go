@@ -0,0 +1,66 @@
+package resizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writePresets(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "presets.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPresets(t *testing.T) {
+	assert := assert.New(t)
+
+	path := writePresets(t, `{
+		"avatar": {"width": 128, "height": 128, "fit": "fill", "format": "jpeg", "quality": 80},
+		"banner": {"width": 1200, "height": 300, "fit": "fill-and-crop"}
+	}`)
+
+	presets, err := LoadPresets(path)
+	assert.NoError(err)
+	assert.Len(presets.All(), 2)
+
+	avatar, ok := presets.Get("avatar")
+	assert.True(ok)
+
+	variant, err := avatar.Variant("avatar", "png")
+	assert.NoError(err)
+	assert.Equal(ResizeOptions{Width: 128, Height: 128, Fit: FitFill, Filter: DefaultFilter}, variant.Resize)
+	assert.Equal("jpeg", variant.Encoding.Format)
+	assert.Equal(80, variant.Encoding.Quality)
+
+	banner, _ := presets.Get("banner")
+	variant, err = banner.Variant("banner", "webp")
+	assert.NoError(err)
+	assert.Equal("png", variant.Encoding.Format)
+
+	_, ok = presets.Get("unknown")
+	assert.False(ok)
+}
+
+func TestLoadPresetsErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	presets, err := LoadPresets(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NoError(err)
+	assert.Empty(presets.All())
+
+	for _, content := range []string{
+		`not json`,
+		`{"avatar": {"width": 0, "height": 0}}`,
+		`{"avatar": {"width": 10, "format": "xcf"}}`,
+		`{"bad name": {"width": 10}}`,
+		`{"avatar": {"width": 10, "filter": "sinc"}}`,
+	} {
+		_, err := LoadPresets(writePresets(t, content))
+		assert.Error(err, content)
+	}
+}
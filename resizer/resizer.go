@@ -1,80 +1,472 @@
 package resizer
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
+	"imageResizerX/codec"
 	"imageResizerX/domain"
 	"imageResizerX/logs"
-	"mime/multipart"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
+	_ "golang.org/x/image/webp"
 )
 
+// Image is an uploaded image, Data holds its encoded bytes.
 type Image struct {
-	File     multipart.File
+	Data     []byte
 	Filename string
 	Format   string
 }
 
+// Storer keeps the resized images. Save gives up with ctx's error once it is
+// done, leaving nothing stored.
 type Storer interface {
-	Save(img *domain.ImageResized) error
+	Save(ctx context.Context, img *domain.ImageResized) (domain.ObjectInfo, error)
+	Stat(name string) (domain.ObjectInfo, error)
+	Delete(name string) error
+	List() ([]domain.ObjectInfo, error)
+	Usage() (domain.StorageUsage, error)
+}
+
+// RecordStore keeps the record of every stored image, by the name it is
+// stored under.
+type RecordStore interface {
+	Put(record domain.ImageRecord) error
+	Get(id string) (domain.ImageRecord, error)
+	// FindByKey returns the latest record stored for a content key.
+	FindByKey(contentKey string) (domain.ImageRecord, error)
+	Delete(id string) error
+	List() ([]domain.ImageRecord, error)
 }
 
 type ImageResizer struct {
-	resize func(file multipart.File, width int, heigth int) (*image.NRGBA, error)
-	storer Storer
+	decode  func(data []byte, autoOrient bool) (image.Image, domain.Metadata, error)
+	storer  Storer
+	records RecordStore
+	sweeper *sweeper
+	// quota is the most bytes stored, zero for no limit
+	quota int64
+	newID func() string
+	now   func() time.Time
 }
 
-func NewImageResizer(storer Storer) *ImageResizer {
+// NewImageResizer returns a resizer storing up to quota bytes into storer
+// and records, whose expired outputs are swept every sweepInterval and after
+// every store.
+func NewImageResizer(storer Storer, records RecordStore, sweepInterval time.Duration, quota int64) *ImageResizer {
+	sweeper := newSweeper(storer, records)
+	sweeper.start(sweepInterval)
+
 	return &ImageResizer{
-		resize: func(file multipart.File, width, heigth int) (*image.NRGBA, error) {
-			img, err := imaging.Decode(file)
+		decode: func(data []byte, autoOrient bool) (image.Image, domain.Metadata, error) {
+			// the full decode cannot be interrupted, the size is checked
+			// from the header before allocating the bitmap
+			config, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				logs.Logger.Error("Failed to perform image header decode",
+					zap.Error(err),
+				)
+				return nil, domain.Metadata{}, err
+			}
+
+			if pixels := int64(config.Width) * int64(config.Height); pixels > MaxSourcePixels {
+				return nil, domain.Metadata{}, fmt.Errorf("%w: %dx%d pixels", ErrImageTooLarge, config.Width, config.Height)
+			}
+
+			img, err := imaging.Decode(bytes.NewReader(data))
 			if err != nil {
 				logs.Logger.Error("Failed to performe image decode",
 					zap.Error(err),
 				)
-				return nil, err
+				return nil, domain.Metadata{}, err
 			}
-			return imaging.Resize(img, width, heigth, imaging.Lanczos), nil
+
+			// imaging only orients JPEG sources, the EXIF of PNG and WebP
+			// ones is read here as well
+			md := codec.ExtractMetadata(data)
+			if autoOrient {
+				img = orient(img, codec.Orientation(md.Exif))
+			}
+			return img, md, nil
 		},
-		storer: storer,
+		storer:  storer,
+		records: records,
+		sweeper: sweeper,
+		quota:   quota,
+		newID:   uuid.NewString,
+		now:     time.Now,
+	}
+}
+
+// MaxSourcePixels is the most pixels of an upload, larger ones are refused
+// from their header before being decoded.
+const MaxSourcePixels = 100_000_000
+
+// ErrImageTooLarge is returned for uploads over MaxSourcePixels.
+var ErrImageTooLarge = errors.New("image has too many pixels")
+
+// ErrQuotaExceeded is returned when the storage holds as many bytes as it is
+// allowed to, even once the expired images were swept.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// CheckQuota fails once the stored images reach the quota. The expired
+// images are swept right away before giving up. The size of the outputs is
+// not known in advance, so the last images stored may go over the quota.
+func (r *ImageResizer) CheckQuota() error {
+	if r.quota <= 0 {
+		return nil
+	}
+
+	if full, err := r.full(); err != nil || !full {
+		return err
+	}
+
+	r.sweeper.sweep()
+
+	full, err := r.full()
+	if err != nil {
+		return err
+	}
+	if full {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (r *ImageResizer) full() (bool, error) {
+	usage, err := r.storer.Usage()
+	if err != nil {
+		return false, err
+	}
+	return usage.Bytes >= r.quota, nil
+}
+
+// Request is what a client asked to be done with one upload: the
+// operations run on the decoded image and the variants produced from it.
+// AutoOrient rotates the image upright from its EXIF orientation before
+// anything else, Metadata tells what of the upload metadata is kept. Owner
+// is the authenticated client, empty for anonymous uploads. TTL is how long
+// the outputs are kept, domain.ImageLifetime when zero.
+type Request struct {
+	Operations Pipeline
+	Variants   []Variant
+	AutoOrient bool
+	Metadata   domain.MetadataPolicy
+	Owner      string
+	TTL        time.Duration
+}
+
+// KeepForever as the TTL of a request keeps its outputs until they are
+// deleted.
+const KeepForever time.Duration = -1
+
+// Expiry returns when the outputs of req stored at now expire, nil when they
+// are kept forever.
+func (req Request) Expiry(now time.Time) *time.Time {
+	switch req.TTL {
+	case KeepForever:
+		return nil
+	case 0:
+		expiresAt := now.Add(domain.ImageLifetime)
+		return &expiresAt
+	default:
+		expiresAt := now.Add(req.TTL)
+		return &expiresAt
+	}
+}
+
+func (req Request) Validate() error {
+	if err := req.validateSource(); err != nil {
+		return err
+	}
+
+	return ValidateVariants(req.Variants)
+}
+
+func (req Request) validateSource() error {
+	if err := req.Operations.Validate(); err != nil {
+		return err
 	}
+
+	_, err := codec.ParseMetadataPolicy(string(req.Metadata))
+	return err
+}
+
+// source is a decoded upload after the operations ran, with the size it
+// was uploaded at.
+type source struct {
+	img    image.Image
+	md     domain.Metadata
+	width  int
+	height int
 }
 
-func (r *ImageResizer) ResizeImage(originalImage *Image, width, heigth int) (string, error) {
+// decodeSource reads the upload, keeps the metadata allowed by req and runs its
+// operations, unless ctx is done by then.
+func (r *ImageResizer) decodeSource(ctx context.Context, originalImage *Image, req Request) (*source, error) {
+	img, md, err := r.decode(originalImage.Data, req.AutoOrient)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	src := &source{width: img.Bounds().Dx(), height: img.Bounds().Dy()}
+
+	policy, _ := codec.ParseMetadataPolicy(string(req.Metadata))
+	src.md = codec.FilterMetadata(md, policy, req.AutoOrient)
 
-	img, err := r.resize(originalImage.File, width, heigth)
+	src.img, err = req.Operations.Apply(ctx, img)
 	if err != nil {
+		return nil, err
+	}
+
+	return src, nil
+}
+
+// Resize decodes the image, runs the operations of req and resizes the
+// result without storing it. Variants of req are ignored, the returned
+// metadata is what the policy of req allows to be written out. It gives up
+// with ctx's error when ctx is done between two steps.
+func (r *ImageResizer) Resize(ctx context.Context, originalImage *Image, req Request, opts ResizeOptions) (*image.NRGBA, domain.Metadata, error) {
+	if err := req.validateSource(); err != nil {
+		return nil, domain.Metadata{}, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, domain.Metadata{}, err
+	}
+
+	src, err := r.decodeSource(ctx, originalImage, req)
+	if err != nil {
+		return nil, domain.Metadata{}, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, domain.Metadata{}, err
+	}
+
+	return opts.Apply(src.img), src.md, nil
+}
+
+// Process decodes the image and runs the operations once, then hands every
+// variant to runTask to be resized and stored. done is called a single
+// time, after the last variant finished, with the stored names in the
+// order of the variants. Once ctx is done the remaining steps are skipped,
+// the variants already stored are removed and done gets ctx's error. They
+// are removed too when another variant fails; the storage being unavailable
+// and the failures of the record store match ErrTransient.
+func (r *ImageResizer) Process(
+	ctx context.Context,
+	originalImage *Image,
+	req Request,
+	runTask func(task func()),
+	done func(results []VariantResult, err error),
+) {
+	if err := req.Validate(); err != nil {
+		done(nil, err)
+		return
+	}
+
+	if err := ctx.Err(); err != nil {
+		done(nil, err)
+		return
+	}
+
+	src, err := r.decodeSource(ctx, originalImage, req)
+	if err != nil {
+		done(nil, err)
+		return
+	}
+
+	variants := req.Variants
+	batch := newVariantBatch(variants, func(results []VariantResult, err error) {
+		if ctx.Err() != nil {
+			r.Discard(results)
+			done(nil, ctx.Err())
+			return
+		}
+
+		// a job retried after a failure stores its variants again
+		if err != nil {
+			r.Discard(results)
+			done(nil, err)
+			return
+		}
+
+		done(results, nil)
+	})
+
+	for i, variant := range variants {
+		i, variant := i, variant
+		runTask(func() {
+			name, err := r.storeVariant(ctx, src, originalImage, req, variant)
+			batch.finish(i, name, err)
+		})
+	}
+}
+
+// Discard removes the variants of a job stored before it was cancelled or
+// failed.
+func (r *ImageResizer) Discard(results []VariantResult) {
+	for _, result := range results {
+		if result.Filename == "" {
+			continue
+		}
+
+		if err := r.records.Delete(result.Filename); err != nil {
+			logs.Logger.Error("Failed to perform record delete", zap.String("id", result.Filename), zap.Error(err))
+		}
+
+		if err := r.storer.Delete(result.Filename); err != nil {
+			logs.Logger.Error("Failed to perform image delete", zap.String("id", result.Filename), zap.Error(err))
+		}
+	}
+}
+
+// storeVariant saves variant under a random id, so names can neither
+// collide nor be guessed, and records it with its content key. ctx is
+// checked before the resize and handed to the save.
+func (r *ImageResizer) storeVariant(ctx context.Context, src *source, originalImage *Image, req Request, variant Variant) (string, error) {
+	if err := ctx.Err(); err != nil {
 		return "", err
 	}
 
-	uniqueName := r.generateUniqueFilename(originalImage.Filename)
+	// uploads running together could otherwise all pass the upload check
+	if err := r.CheckQuota(); err != nil {
+		return "", err
+	}
+
+	name := r.newID() + codec.Extension(variant.Encoding.Format)
 
 	resizedImg := &domain.ImageResized{
-		Img:    img,
-		Name:   uniqueName,
-		Format: originalImage.Format,
+		Img:      variant.Resize.Apply(src.img),
+		Name:     name,
+		Encoding: variant.Encoding,
+		Metadata: src.md,
 	}
 
-	err = r.save(resizedImg)
+	info, err := r.save(ctx, resizedImg)
+	if errors.Is(err, domain.ErrStorageUnavailable) {
+		return "", transient(err)
+	}
 	if err != nil {
 		return "", err
 	}
 
-	return uniqueName, nil
+	params := newKeyParams(req, variant)
+	operations, _ := json.Marshal(params)
+	now := r.now()
+
+	record := domain.ImageRecord{
+		ID:               name,
+		ContentKey:       contentKey(originalImage.Data, req, variant),
+		Owner:            req.Owner,
+		OriginalFilename: originalImage.Filename,
+		DownloadFilename: downloadFilename(originalImage.Filename, variant),
+		Variant:          variant.Name,
+		SourceWidth:      src.width,
+		SourceHeight:     src.height,
+		Width:            resizedImg.Img.Bounds().Dx(),
+		Height:           resizedImg.Img.Bounds().Dy(),
+		Format:           params.Format,
+		Size:             info.Size,
+		Checksum:         info.Checksum,
+		Operations:       operations,
+		CreatedAt:        now,
+		ExpiresAt:        req.Expiry(now),
+	}
+
+	if err := r.records.Put(record); err != nil {
+		logs.Logger.Error("Failed to perform record save",
+			zap.String("id", name),
+			zap.Error(err),
+		)
+		// without its record the image could never be downloaded
+		r.storer.Delete(name)
+		return "", transient(err)
+	}
+
+	r.sweeper.trigger()
+	return name, nil
+}
 
+// downloadFilename is the name the variant is downloaded as, after the
+// uploaded file.
+func downloadFilename(originalFilename string, variant Variant) string {
+	base := strings.TrimSuffix(filepath.Base(originalFilename), filepath.Ext(originalFilename))
+	if variant.Name != DefaultVariant {
+		base = base + "_" + variant.Name
+	}
+	return base + codec.Extension(variant.Encoding.Format)
 }
 
-func (r *ImageResizer) generateUniqueFilename(originalFilename string) string {
-	sufix := filepath.Ext(originalFilename)
-	base := strings.TrimSuffix(originalFilename, sufix)
-	return fmt.Sprintf("%s_%d%s", base, time.Now().Unix(), sufix)
+// Lookup returns the stored results of a request already processed for
+// the same image, without decoding it, and when the first of them expires,
+// nil when they are all kept forever. ok is false as soon as one variant is
+// missing or expired. The results found are kept at least as long as req
+// asks.
+func (r *ImageResizer) Lookup(originalImage *Image, req Request) (results []VariantResult, expiresAt *time.Time, ok bool) {
+	if req.Validate() != nil {
+		return nil, nil, false
+	}
+
+	now := r.now()
+	records := make([]domain.ImageRecord, len(req.Variants))
+
+	for i, variant := range req.Variants {
+		record, err := r.records.FindByKey(contentKey(originalImage.Data, req, variant))
+		if err != nil || record.IsExpired(now) {
+			return nil, nil, false
+		}
+
+		if _, err := r.storer.Stat(record.ID); err != nil {
+			return nil, nil, false
+		}
+
+		records[i] = record
+	}
+
+	results = make([]VariantResult, len(req.Variants))
+	wanted := req.Expiry(now)
+
+	for i, record := range records {
+		if expiresLater(wanted, record.ExpiresAt) {
+			record.ExpiresAt = wanted
+			if err := r.records.Put(record); err != nil {
+				return nil, nil, false
+			}
+		}
+
+		if i == 0 || expiresLater(expiresAt, record.ExpiresAt) {
+			expiresAt = record.ExpiresAt
+		}
+
+		results[i] = VariantResult{Name: req.Variants[i].Name, Filename: record.ID}
+	}
+
+	return results, expiresAt, true
+}
+
+// expiresLater tells whether a expires after b, nil meaning never.
+func expiresLater(a *time.Time, b *time.Time) bool {
+	if b == nil {
+		return false
+	}
+	return a == nil || a.After(*b)
 }
 
-func (r *ImageResizer) save(img *domain.ImageResized) error {
-	return r.storer.Save(img)
+func (r *ImageResizer) save(ctx context.Context, img *domain.ImageResized) (domain.ObjectInfo, error) {
+	return r.storer.Save(ctx, img)
 }
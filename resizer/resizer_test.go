@@ -1,42 +1,99 @@
 package resizer
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"image"
+	"imageResizerX/codec"
 	"imageResizerX/domain"
-	"mime/multipart"
+	"os"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
 )
 
 type StoreStub struct {
 	data     map[string]*domain.ImageResized
+	savedAt  map[string]time.Time
 	dataLock sync.RWMutex
+	err      error
 }
 
 func NewStoreStub() *StoreStub {
 	return &StoreStub{
-		data: make(map[string]*domain.ImageResized),
+		data:    make(map[string]*domain.ImageResized),
+		savedAt: make(map[string]time.Time),
 	}
 }
 
-func (s *StoreStub) Save(img *domain.ImageResized) error {
+func (s *StoreStub) Save(ctx context.Context, img *domain.ImageResized) (domain.ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return domain.ObjectInfo{}, err
+	}
 
-	if strings.Contains(img.Name, "error") {
-		return errors.New("error saving on db")
+	if s.err != nil {
+		return domain.ObjectInfo{}, s.err
 	}
 
 	s.dataLock.Lock()
 	s.data[img.Name] = img
+	s.savedAt[img.Name] = time.Now()
 	s.dataLock.Unlock()
+	return domain.ObjectInfo{Name: img.Name, Size: 1, ModTime: s.savedAt[img.Name], Checksum: "checksum"}, nil
+}
+
+func (s *StoreStub) Stat(name string) (domain.ObjectInfo, error) {
+	s.dataLock.RLock()
+	defer s.dataLock.RUnlock()
+
+	savedAt, ok := s.savedAt[name]
+	if !ok {
+		return domain.ObjectInfo{}, domain.ErrObjectNotFound
+	}
+
+	return domain.ObjectInfo{Name: name, ModTime: savedAt}, nil
+}
+
+func (s *StoreStub) Delete(name string) error {
+	s.dataLock.Lock()
+	defer s.dataLock.Unlock()
+
+	if _, ok := s.data[name]; !ok {
+		return domain.ErrObjectNotFound
+	}
+
+	delete(s.data, name)
+	delete(s.savedAt, name)
 	return nil
 }
 
+func (s *StoreStub) List() ([]domain.ObjectInfo, error) {
+	s.dataLock.RLock()
+	defer s.dataLock.RUnlock()
+
+	objects := []domain.ObjectInfo{}
+	for name, savedAt := range s.savedAt {
+		objects = append(objects, domain.ObjectInfo{Name: name, ModTime: savedAt})
+	}
+	return objects, nil
+}
+
+// Usage counts every stored image as one byte.
+func (s *StoreStub) Usage() (domain.StorageUsage, error) {
+	s.dataLock.RLock()
+	defer s.dataLock.RUnlock()
+
+	return domain.StorageUsage{Bytes: int64(len(s.data)), Objects: len(s.data)}, nil
+}
+
 func (s *StoreStub) Get(name string) *domain.ImageResized {
 	s.dataLock.RLock()
 	defer s.dataLock.RUnlock()
@@ -49,76 +106,460 @@ func (s *StoreStub) Get(name string) *domain.ImageResized {
 	return img
 }
 
-type FileStub struct{ mock.Mock }
+type RecordStoreStub struct {
+	records map[string]domain.ImageRecord
+	lock    sync.Mutex
+}
+
+func NewRecordStoreStub() *RecordStoreStub {
+	return &RecordStoreStub{records: make(map[string]domain.ImageRecord)}
+}
+
+func (s *RecordStoreStub) Put(record domain.ImageRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.records[record.ID] = record
+	return nil
+}
+
+func (s *RecordStoreStub) Get(id string) (domain.ImageRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return domain.ImageRecord{}, domain.ErrRecordNotFound
+	}
+	return record, nil
+}
+
+func (s *RecordStoreStub) FindByKey(contentKey string) (domain.ImageRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, record := range s.records {
+		if record.ContentKey == contentKey {
+			return record, nil
+		}
+	}
+	return domain.ImageRecord{}, domain.ErrRecordNotFound
+}
+
+func (s *RecordStoreStub) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-func (f *FileStub) Read(p []byte) (n int, err error) {
-	args := f.Called()
-	result := args.Get(0)
-	return result.(int), args.Error(1)
+	if _, ok := s.records[id]; !ok {
+		return domain.ErrRecordNotFound
+	}
+	delete(s.records, id)
+	return nil
 }
 
-func (f *FileStub) ReadAt(p []byte, off int64) (n int, err error) {
-	args := f.Called()
-	result := args.Get(0)
-	return result.(int), args.Error(1)
+func (s *RecordStoreStub) List() ([]domain.ImageRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records := []domain.ImageRecord{}
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
 }
 
-func (f *FileStub) Seek(offset int64, whence int) (int64, error) {
-	args := f.Called()
-	result := args.Get(0)
-	return result.(int64), args.Error(1)
+func runNow(task func()) {
+	task()
 }
 
-func (f *FileStub) Close() error {
-	args := f.Called()
-	return args.Error(0)
+func newTestResizer(storer Storer) *ImageResizer {
+	records := NewRecordStoreStub()
+
+	return &ImageResizer{
+		decode: func(data []byte, autoOrient bool) (image.Image, domain.Metadata, error) {
+			return image.NewNRGBA(image.Rect(0, 0, 40, 20)), domain.Metadata{}, nil
+		},
+		storer:  storer,
+		records: records,
+		sweeper: newSweeper(storer, records),
+		newID:   uuid.NewString,
+		now:     time.Now,
+	}
 }
 
 func TestResizeImage(t *testing.T) {
 	assert := assert.New(t)
-	storer := NewStoreStub()
 
-	resizer := &ImageResizer{
-		resize: func(file multipart.File, width int, heigth int) (*image.NRGBA, error) {
-			return &image.NRGBA{}, nil
-		},
-		storer: storer,
+	type testCase struct {
+		image    *Image
+		storeErr error
 	}
 
-	for _, scenerio := range []*Image{
-		{
-			File:     &FileStub{},
-			Filename: "test1.png",
-			Format:   "png",
-		},
-		{
-			File:     &FileStub{},
-			Filename: "test2.jpg",
-			Format:   "jpg",
-		},
-		{
-			File:     &FileStub{},
-			Filename: "error1.jpg",
-			Format:   "jpg",
-		},
-		{
-			File:     &FileStub{},
-			Filename: "error2.jpg",
-			Format:   "jpg",
-		},
+	for _, scenerio := range []testCase{
+		{image: &Image{Data: []byte("test1"), Filename: "test1.png", Format: "png"}},
+		{image: &Image{Data: []byte("test2"), Filename: "test2.jpg", Format: "jpg"}},
+		{image: &Image{Data: []byte("error1"), Filename: "error1.jpg", Format: "jpg"}, storeErr: errors.New("error saving on db")},
 	} {
-		t.Run(scenerio.Filename, func(t *testing.T) {
-			uniqueName, err := resizer.ResizeImage(scenerio, 200, 300)
+		t.Run(scenerio.image.Filename, func(t *testing.T) {
+			storer := NewStoreStub()
+			storer.err = scenerio.storeErr
+			resizer := newTestResizer(storer)
+
+			var uniqueName string
+			var err error
+
+			resizer.Process(
+				context.Background(),
+				scenerio.image,
+				Request{Variants: []Variant{{
+					Name:     DefaultVariant,
+					Resize:   ResizeOptions{Width: 200, Height: 300},
+					Encoding: domain.EncodeOptions{Format: scenerio.image.Format},
+				}}},
+				runNow,
+				func(results []VariantResult, resizeErr error) {
+					err = resizeErr
+					if err == nil {
+						uniqueName = results[0].Filename
+					}
+				})
 			img := storer.Get(uniqueName)
 
-			if err != nil {
+			if scenerio.storeErr != nil {
+				assert.ErrorIs(err, scenerio.storeErr)
 				assert.Nil(img)
 				return
 			}
 
-			assert.Contains(uniqueName, strings.Replace(scenerio.Filename, fmt.Sprintf(".%s", scenerio.Format), "", -1))
+			assert.NoError(err)
 			assert.NotNil(img)
+			assert.True(strings.HasSuffix(uniqueName, codec.Extension(scenerio.image.Format)))
+		})
+	}
+
+}
+
+func TestResizeWebp(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := os.ReadFile("testdata/sample.webp")
+	assert.NoError(err)
+
+	resizer := NewImageResizer(NewStoreStub(), NewRecordStoreStub(), time.Minute, 0)
+	img, _, err := resizer.Resize(context.Background(), &Image{Data: data, Filename: "sample.webp", Format: "webp"}, Request{}, ResizeOptions{Width: 50})
+
+	assert.NoError(err)
+	assert.Equal(50, img.Bounds().Dx())
+}
+
+// orientedImage is a 40x20 image encoded to format, whose EXIF asks viewers
+// to rotate it 90 degrees clockwise, and carries a GPS IFD pointer.
+func orientedImage(t *testing.T, format string) []byte {
+	exif := make([]byte, 38)
+	copy(exif, "II*\x00")
+	binary.LittleEndian.PutUint32(exif[4:], 8)
+	binary.LittleEndian.PutUint16(exif[8:], 2)
+	binary.LittleEndian.PutUint16(exif[10:], 0x0112)
+	binary.LittleEndian.PutUint16(exif[12:], 3)
+	binary.LittleEndian.PutUint32(exif[14:], 1)
+	binary.LittleEndian.PutUint16(exif[18:], 6)
+	binary.LittleEndian.PutUint16(exif[22:], 0x8825)
+	binary.LittleEndian.PutUint16(exif[24:], 4)
+	binary.LittleEndian.PutUint32(exif[26:], 1)
+
+	var out bytes.Buffer
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	if err := codec.Encode(&out, img, domain.EncodeOptions{Format: format}, domain.Metadata{Exif: exif}); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestResizeAutoOrient(t *testing.T) {
+	assert := assert.New(t)
+	resizer := NewImageResizer(NewStoreStub(), NewRecordStoreStub(), time.Minute, 0)
+
+	type testCase struct {
+		name              string
+		format            string
+		req               Request
+		expectResult      image.Point
+		expectOrientation int
+	}
+
+	for _, scenario := range []testCase{
+		{name: "auto orient", format: "jpeg", req: Request{AutoOrient: true}, expectResult: image.Pt(10, 20)},
+		{name: "keep orientation", format: "jpeg", req: Request{}, expectResult: image.Pt(10, 5)},
+		{name: "keep metadata", format: "jpeg", req: Request{AutoOrient: true, Metadata: domain.MetadataAll}, expectResult: image.Pt(10, 20), expectOrientation: 1},
+		{name: "png auto orient", format: "png", req: Request{AutoOrient: true, Metadata: domain.MetadataAll}, expectResult: image.Pt(10, 20), expectOrientation: 1},
+		{name: "png keep orientation", format: "png", req: Request{Metadata: domain.MetadataAll}, expectResult: image.Pt(10, 5), expectOrientation: 6},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			data := orientedImage(t, scenario.format)
+			img, md, err := resizer.Resize(context.Background(), &Image{Data: data, Filename: "photo." + scenario.format, Format: scenario.format}, scenario.req, ResizeOptions{Width: 10})
+			assert.NoError(err)
+			assert.Equal(scenario.expectResult, img.Bounds().Size())
+			assert.Equal(scenario.expectOrientation > 0, len(md.Exif) > 0)
+			if scenario.expectOrientation > 0 {
+				assert.Equal(scenario.expectOrientation, codec.Orientation(md.Exif))
+			}
 		})
 	}
 
+	_, _, err := resizer.Resize(context.Background(), &Image{Data: orientedImage(t, "jpeg")}, Request{Metadata: "gps"}, ResizeOptions{Width: 10})
+	assert.Error(err)
+}
+
+// pngHeader is the start of a PNG claiming width x height pixels, enough
+// for its header to be decoded but not its pixels.
+func pngHeader(width, height uint32) []byte {
+	chunk := make([]byte, 17)
+	copy(chunk, "IHDR")
+	binary.BigEndian.PutUint32(chunk[4:], width)
+	binary.BigEndian.PutUint32(chunk[8:], height)
+	chunk[12] = 8
+	chunk[13] = 6
+
+	out := []byte("\x89PNG\r\n\x1a\n")
+	out = binary.BigEndian.AppendUint32(out, 13)
+	out = append(out, chunk...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(chunk))
+}
+
+func TestResizeTooLarge(t *testing.T) {
+	assert := assert.New(t)
+	resizer := NewImageResizer(NewStoreStub(), NewRecordStoreStub(), time.Minute, 0)
+
+	_, _, err := resizer.Resize(context.Background(), &Image{Data: pngHeader(20000, 20000), Format: "png"}, Request{}, ResizeOptions{Width: 10})
+	assert.ErrorIs(err, ErrImageTooLarge)
+
+	_, _, err = resizer.Resize(context.Background(), &Image{Data: pngHeader(10000, 10000), Format: "png"}, Request{}, ResizeOptions{Width: 10})
+	assert.Error(err)
+	assert.NotErrorIs(err, ErrImageTooLarge)
+}
+
+func TestProcessVariants(t *testing.T) {
+	assert := assert.New(t)
+	storer := NewStoreStub()
+	resizer := newTestResizer(storer)
+
+	variants := []Variant{
+		{Name: "thumb", Resize: ResizeOptions{Width: 10, Height: 10, Fit: FitFill}, Encoding: domain.EncodeOptions{Format: "jpeg"}},
+		{Name: "small", Resize: ResizeOptions{Width: 20}, Encoding: domain.EncodeOptions{Format: "png"}},
+	}
+
+	calls := 0
+	var results []VariantResult
+
+	resizer.Process(
+		context.Background(),
+		&Image{Data: []byte("photo"), Filename: "photo.png", Format: "png"},
+		Request{Variants: variants},
+		runNow,
+		func(r []VariantResult, err error) {
+			calls++
+			assert.NoError(err)
+			results = r
+		})
+
+	assert.Equal(1, calls)
+	assert.Len(results, 2)
+	assert.Equal("thumb", results[0].Name)
+	assert.True(strings.HasSuffix(results[0].Filename, ".jpeg"))
+	assert.NotEqual(results[0].Filename, results[1].Filename)
+
+	record, err := resizer.records.Get(results[0].Filename)
+	assert.NoError(err)
+	assert.Equal("photo_thumb.jpeg", record.DownloadFilename)
+	assert.Equal("photo.png", record.OriginalFilename)
+	assert.Equal([4]int{40, 20, 10, 10}, [4]int{record.SourceWidth, record.SourceHeight, record.Width, record.Height})
+	assert.Equal("jpeg", record.Format)
+	assert.Equal("checksum", record.Checksum)
+	assert.JSONEq(`{"operations":null,"auto_orient":false,"metadata":"strip","width":10,"height":10,"fit":"fill","filter":"lanczos","format":"jpeg","quality":0,"compression":""}`, string(record.Operations))
+	assert.Equal(domain.ImageLifetime, record.ExpiresAt.Sub(record.CreatedAt))
+
+	expiry := Request{TTL: time.Hour}.Expiry(record.CreatedAt)
+	assert.Equal(time.Hour, expiry.Sub(record.CreatedAt))
+	assert.Nil(Request{TTL: KeepForever}.Expiry(record.CreatedAt))
+	assert.Equal(image.Pt(10, 10), storer.Get(results[0].Filename).Img.Bounds().Size())
+	assert.Equal(image.Pt(20, 10), storer.Get(results[1].Filename).Img.Bounds().Size())
+}
+
+func TestProcessCancelled(t *testing.T) {
+	assert := assert.New(t)
+	storer := NewStoreStub()
+	resizer := newTestResizer(storer)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	variants := []Variant{
+		{Name: "thumb", Resize: ResizeOptions{Width: 10}, Encoding: domain.EncodeOptions{Format: "png"}},
+		{Name: "small", Resize: ResizeOptions{Width: 20}, Encoding: domain.EncodeOptions{Format: "png"}},
+	}
+
+	var err error
+	resizer.Process(
+		ctx,
+		&Image{Data: []byte("photo"), Filename: "photo.png", Format: "png"},
+		Request{Variants: variants},
+		func(task func()) {
+			// cancelled once the first variant is stored
+			task()
+			cancel()
+		},
+		func(r []VariantResult, processErr error) {
+			err = processErr
+		})
+
+	assert.ErrorIs(err, context.Canceled)
+	objects, _ := storer.List()
+	assert.Empty(objects)
+	records, _ := resizer.records.List()
+	assert.Empty(records)
+}
+
+func TestProcessFailures(t *testing.T) {
+	type testCase struct {
+		name            string
+		saveErr         error
+		decodeErr       error
+		expectTransient bool
+	}
+
+	for _, scenario := range []testCase{
+		{name: "storage unavailable", saveErr: fmt.Errorf("%w: connection refused", domain.ErrStorageUnavailable), expectTransient: true},
+		{name: "decode failure", decodeErr: errors.New("unknown image format")},
+		{name: "encode failure", saveErr: errors.New("unsupported format")},
+		{name: "memory budget", saveErr: errors.New("image of 301 bytes does not fit the memory budget of 300 bytes")},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			assert := assert.New(t)
+			storer := NewStoreStub()
+			storer.err = scenario.saveErr
+			resizer := newTestResizer(storer)
+			if scenario.decodeErr != nil {
+				resizer.decode = func(data []byte, autoOrient bool) (image.Image, domain.Metadata, error) {
+					return nil, domain.Metadata{}, scenario.decodeErr
+				}
+			}
+
+			var err error
+			resizer.Process(
+				context.Background(),
+				&Image{Data: []byte("photo"), Filename: "photo.png", Format: "png"},
+				Request{Variants: []Variant{{Name: "thumb", Resize: ResizeOptions{Width: 10}, Encoding: domain.EncodeOptions{Format: "png"}}}},
+				runNow,
+				func(r []VariantResult, processErr error) {
+					err = processErr
+				})
+
+			assert.Error(err)
+			assert.Equal(scenario.expectTransient, errors.Is(err, ErrTransient))
+		})
+	}
+}
+
+func TestValidateVariants(t *testing.T) {
+	assert := assert.New(t)
+	valid := Variant{Name: "thumb", Resize: ResizeOptions{Width: 10}, Encoding: domain.EncodeOptions{Format: "png"}}
+
+	type testCase struct {
+		name        string
+		variants    []Variant
+		expectError bool
+	}
+
+	for _, scenario := range []testCase{
+		{name: "valid", variants: []Variant{valid}},
+		{name: "empty", variants: []Variant{}, expectError: true},
+		{name: "duplicated", variants: []Variant{valid, valid}, expectError: true},
+		{name: "bad name", variants: []Variant{{Name: "../x", Resize: valid.Resize, Encoding: valid.Encoding}}, expectError: true},
+		{name: "bad format", variants: []Variant{{Name: "x", Resize: valid.Resize, Encoding: domain.EncodeOptions{Format: "xcf"}}}, expectError: true},
+	} {
+		t.Run(scenario.name, func(t *testing.T) {
+			err := ValidateVariants(scenario.variants)
+
+			if scenario.expectError {
+				assert.Error(err)
+				return
+			}
+
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestProcessOperations(t *testing.T) {
+	assert := assert.New(t)
+	storer := NewStoreStub()
+	resizer := newTestResizer(storer)
+
+	var results []VariantResult
+	var err error
+
+	resizer.Process(
+		context.Background(),
+		&Image{Data: []byte("photo"), Filename: "photo.png", Format: "png"},
+		Request{
+			Operations: Pipeline{{Op: OpRotate, Angle: 90}, {Op: OpCrop, Width: 10, Height: 30, Anchor: "center"}},
+			Variants:   []Variant{{Name: DefaultVariant, Resize: ResizeOptions{Height: 60}, Encoding: domain.EncodeOptions{Format: "png"}}},
+		},
+		runNow,
+		func(r []VariantResult, processErr error) {
+			results, err = r, processErr
+		})
+
+	assert.NoError(err)
+	assert.Equal(image.Pt(20, 60), storer.Get(results[0].Filename).Img.Bounds().Size())
+
+	resizer.Process(
+		context.Background(),
+		&Image{Data: []byte("photo"), Filename: "photo.png", Format: "png"},
+		Request{
+			Operations: Pipeline{{Op: "emboss"}},
+			Variants:   []Variant{{Name: DefaultVariant, Resize: ResizeOptions{Height: 60}, Encoding: domain.EncodeOptions{Format: "png"}}},
+		},
+		runNow,
+		func(r []VariantResult, processErr error) {
+			results, err = r, processErr
+		})
+
+	assert.Error(err)
+	assert.Nil(results)
+}
+
+func TestCheckQuota(t *testing.T) {
+	assert := assert.New(t)
+	storer := NewStoreStub()
+	resizer := newTestResizer(storer)
+	resizer.quota = 3
+
+	upload := func(data string) error {
+		var err error
+		resizer.Process(
+			context.Background(),
+			&Image{Data: []byte(data), Filename: "photo.png", Format: "png"},
+			Request{TTL: time.Minute, Variants: []Variant{{Name: DefaultVariant, Resize: ResizeOptions{Width: 10}, Encoding: domain.EncodeOptions{Format: "png"}}}},
+			runNow,
+			func(results []VariantResult, processErr error) { err = processErr })
+		return err
+	}
+
+	for _, data := range []string{"a", "b", "c"} {
+		assert.NoError(upload(data))
+	}
+
+	assert.ErrorIs(resizer.CheckQuota(), ErrQuotaExceeded)
+	assert.ErrorIs(upload("d"), ErrQuotaExceeded)
+	// sweeps take longer than a retry to free space
+	assert.NotErrorIs(upload("d"), ErrTransient)
+
+	// the eager sweep frees the expired images
+	now := time.Now().Add(time.Minute)
+	resizer.sweeper.now = func() time.Time { return now }
+	assert.NoError(resizer.CheckQuota())
+
+	storer.Save(context.Background(), &domain.ImageResized{Name: "big.png"})
+	storer.Save(context.Background(), &domain.ImageResized{Name: "bigger.png"})
+	storer.Save(context.Background(), &domain.ImageResized{Name: "biggest.png"})
+	resizer.quota = 0
+	assert.NoError(resizer.CheckQuota())
 }
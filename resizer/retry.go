@@ -0,0 +1,71 @@
+package resizer
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrTransient matches the failures that may not happen again when the job
+// is retried: the storage backend or the record store failing, not the
+// upload. The others, like an image that cannot be decoded or encoded or a
+// full quota, are permanent.
+var ErrTransient = errors.New("transient failure")
+
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e transientError) Unwrap() error {
+	return e.err
+}
+
+func (e transientError) Is(target error) bool {
+	return target == ErrTransient
+}
+
+// transient marks err as worth retrying. The context errors are left as
+// they are, a job cancelled or out of time is not retried.
+func transient(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return transientError{err: err}
+}
+
+// RetryPolicy tells how often a job failing with a transient error is run
+// again. The wait before each retry doubles from Backoff, up to MaxBackoff,
+// and is drawn at random in its upper half so jobs failing together do not
+// retry together.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Retry tells whether a job whose attempt failed with err is run again.
+func (p RetryPolicy) Retry(attempt int, err error) bool {
+	return errors.Is(err, ErrTransient) && attempt < p.MaxAttempts
+}
+
+// Delay is the wait after the failed attempt, counted from 1.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
@@ -0,0 +1,58 @@
+package resizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransient(t *testing.T) {
+	assert := assert.New(t)
+	cause := errors.New("disk full")
+
+	err := transient(cause)
+	assert.ErrorIs(err, ErrTransient)
+	assert.ErrorIs(err, cause)
+	assert.Equal("disk full", err.Error())
+
+	assert.NoError(transient(nil))
+	assert.NotErrorIs(transient(context.Canceled), ErrTransient)
+	assert.NotErrorIs(cause, ErrTransient)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Second * 3}
+
+	type testCase struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}
+
+	for _, scenario := range []testCase{
+		{attempt: 1, min: time.Millisecond * 500, max: time.Second},
+		{attempt: 2, min: time.Second, max: time.Second * 2},
+		{attempt: 3, min: time.Millisecond * 1500, max: time.Second * 3},
+		{attempt: 10, min: time.Millisecond * 1500, max: time.Second * 3},
+	} {
+		t.Run(fmt.Sprintf("attempt %d", scenario.attempt), func(t *testing.T) {
+			assert := assert.New(t)
+
+			for i := 0; i < 100; i++ {
+				delay := policy.Delay(scenario.attempt)
+				assert.GreaterOrEqual(delay, scenario.min)
+				assert.LessOrEqual(delay, scenario.max)
+			}
+		})
+	}
+
+	assert := assert.New(t)
+	failure := transient(errors.New("backend unavailable"))
+	assert.True(policy.Retry(2, failure))
+	assert.False(policy.Retry(3, failure))
+	assert.False(policy.Retry(1, errors.New("unknown image format")))
+}
@@ -0,0 +1,120 @@
+package resizer
+
+import (
+	"errors"
+	"imageResizerX/domain"
+	"imageResizerX/logs"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// recordRetention is how long the record of an expired image is kept after
+// the image is deleted, so its downloads can be told apart from unknown ones.
+const recordRetention = time.Hour * 24
+
+// sweeper deletes the images whose record expired, and the records once
+// they are older than recordRetention. Stored images without a record are
+// left domain.ImageLifetime to get one before they are deleted too.
+type sweeper struct {
+	storer  Storer
+	records RecordStore
+	now     func() time.Time
+	sweepCh chan struct{}
+	// lock keeps the eager sweeps of a full storage from running along the
+	// periodic one
+	lock sync.Mutex
+}
+
+func newSweeper(storer Storer, records RecordStore) *sweeper {
+	return &sweeper{
+		storer:  storer,
+		records: records,
+		now:     time.Now,
+		sweepCh: make(chan struct{}, 1),
+	}
+}
+
+// start sweeps every interval, and whenever triggered.
+func (s *sweeper) start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.sweepCh:
+			}
+			s.sweep()
+		}
+	}()
+}
+
+// trigger asks for a sweep without waiting for it.
+func (s *sweeper) trigger() {
+	select {
+	case s.sweepCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *sweeper) sweep() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+
+	// listed first, so images saved meanwhile are not taken for orphans
+	objects, err := s.storer.List()
+	if err != nil {
+		logs.Logger.Error("Failed to list stored images", zap.Error(err))
+		return
+	}
+
+	stored := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		stored[object.Name] = true
+	}
+
+	records, err := s.records.List()
+	if err != nil {
+		logs.Logger.Error("Failed to list image records", zap.Error(err))
+		return
+	}
+
+	recorded := make(map[string]bool, len(records))
+	for _, record := range records {
+		recorded[record.ID] = true
+
+		if !record.IsExpired(now) {
+			continue
+		}
+
+		if stored[record.ID] {
+			err := s.storer.Delete(record.ID)
+			if err != nil && !errors.Is(err, domain.ErrObjectNotFound) {
+				logs.Logger.Error(err.Error())
+				continue
+			}
+		}
+
+		if now.Sub(*record.ExpiresAt) <= recordRetention {
+			continue
+		}
+
+		if err := s.records.Delete(record.ID); err != nil {
+			logs.Logger.Error(err.Error())
+		}
+	}
+
+	for _, object := range objects {
+		if recorded[object.Name] || now.Sub(object.ModTime) <= domain.ImageLifetime {
+			continue
+		}
+
+		if err := s.storer.Delete(object.Name); err != nil {
+			logs.Logger.Error(err.Error())
+		}
+	}
+}
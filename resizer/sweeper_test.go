@@ -0,0 +1,49 @@
+package resizer
+
+import (
+	"context"
+	"image"
+	"imageResizerX/domain"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweep(t *testing.T) {
+	assert := assert.New(t)
+
+	storer := NewStoreStub()
+	records := NewRecordStoreStub()
+	sweeper := newSweeper(storer, records)
+
+	now := time.Now()
+	for _, name := range []string{"fresh.png", "forever.png", "expired.png", "orphan.png", "saving.png"} {
+		storer.Save(context.Background(), &domain.ImageResized{Img: image.NewNRGBA(image.Rect(0, 0, 1, 1)), Name: name})
+	}
+	storer.savedAt["orphan.png"] = now.Add(-domain.ImageLifetime - time.Second)
+
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	records.Put(domain.ImageRecord{ID: "fresh.png", ExpiresAt: at(time.Minute)})
+	records.Put(domain.ImageRecord{ID: "forever.png"})
+	records.Put(domain.ImageRecord{ID: "expired.png", ExpiresAt: at(0)})
+	records.Put(domain.ImageRecord{ID: "gone.png", ExpiresAt: at(-recordRetention - time.Second)})
+
+	sweeper.now = func() time.Time { return now }
+	sweeper.sweep()
+
+	for name, kept := range map[string]bool{"fresh.png": true, "forever.png": true, "expired.png": false, "orphan.png": false, "saving.png": true} {
+		_, err := storer.Stat(name)
+		assert.Equal(kept, err == nil, name)
+	}
+
+	// the record of expired.png is kept to answer its downloads with 410
+	for id, kept := range map[string]bool{"fresh.png": true, "forever.png": true, "expired.png": true, "gone.png": false} {
+		_, err := records.Get(id)
+		assert.Equal(kept, err == nil, id)
+	}
+}
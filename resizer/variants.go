@@ -0,0 +1,108 @@
+package resizer
+
+import (
+	"errors"
+	"fmt"
+	"imageResizerX/codec"
+	"imageResizerX/domain"
+	"regexp"
+	"sync"
+)
+
+const MaxVariants = 10
+
+// DefaultVariant is the name of the only variant of uploads that do not
+// ask for a variant list.
+const DefaultVariant = "default"
+
+var variantNameRgx = regexp.MustCompile(`^[A-Za-z0-9-]{1,32}$`)
+
+// Variant is one output generated from an uploaded image.
+type Variant struct {
+	Name     string
+	Resize   ResizeOptions
+	Encoding domain.EncodeOptions
+}
+
+type VariantResult struct {
+	Name     string
+	Filename string
+}
+
+func ValidateVariants(variants []Variant) error {
+	if len(variants) == 0 {
+		return errors.New("at least one variant must be given")
+	}
+
+	if len(variants) > MaxVariants {
+		return fmt.Errorf("at most %d variants can be given", MaxVariants)
+	}
+
+	seen := make(map[string]struct{}, len(variants))
+
+	for _, v := range variants {
+		if !variantNameRgx.MatchString(v.Name) {
+			return fmt.Errorf("invalid variant name %q", v.Name)
+		}
+
+		if _, ok := seen[v.Name]; ok {
+			return fmt.Errorf("duplicated variant name %q", v.Name)
+		}
+		seen[v.Name] = struct{}{}
+
+		if err := v.Resize.Validate(); err != nil {
+			return fmt.Errorf("variant %s: %w", v.Name, err)
+		}
+
+		if err := codec.Validate(v.Encoding); err != nil {
+			return fmt.Errorf("variant %s: %w", v.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// variantBatch collects the results of the variants of one upload and calls
+// done once the last of them finished, with the first error if any.
+type variantBatch struct {
+	lock    sync.Mutex
+	pending int
+	results []VariantResult
+	err     error
+	done    func(results []VariantResult, err error)
+}
+
+func newVariantBatch(variants []Variant, done func(results []VariantResult, err error)) *variantBatch {
+	results := make([]VariantResult, len(variants))
+	for i, v := range variants {
+		results[i].Name = v.Name
+	}
+
+	return &variantBatch{
+		pending: len(variants),
+		results: results,
+		done:    done,
+	}
+}
+
+func (b *variantBatch) finish(index int, filename string, err error) {
+	b.lock.Lock()
+
+	b.results[index].Filename = filename
+	if err != nil && b.err == nil {
+		b.err = err
+	}
+
+	b.pending--
+	last := b.pending == 0
+
+	b.lock.Unlock()
+
+	if !last {
+		return
+	}
+
+	// the variants stored are passed along the error too, for the caller to
+	// clean up
+	b.done(b.results, b.err)
+}
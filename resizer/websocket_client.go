@@ -2,66 +2,125 @@ package resizer
 
 import (
 	"context"
+	"imageResizerX/domain"
 	"imageResizerX/logs"
 	"sync"
 	"time"
 
+	"go.uber.org/zap"
 	"nhooyr.io/websocket"
 	"nhooyr.io/websocket/wsjson"
 )
 
 type WebsocketConn interface {
-	CloseRead(context.Context) context.Context
 	Close(code websocket.StatusCode, reason string) error
 }
 
 type Message struct {
-	Action      string `json:"action"`
-	DownloadUrl string `json:"download_url"`
+	Action      string              `json:"action"`
+	JobID       string              `json:"job_id"`
+	DownloadUrl string              `json:"download_url"`
+	Variants    []domain.JobVariant `json:"variants,omitempty"`
+	Error       string              `json:"error,omitempty"`
 }
 
+// ClientMessage is what a websocket client sends to manage the jobs it
+// wants to hear about.
+type ClientMessage struct {
+	Action string `json:"action"`
+	JobID  string `json:"job_id"`
+}
+
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+	ActionCancel      = "cancel"
+)
+
 type subscription struct {
 	message   chan Message
 	closeSlow func()
+	// closeGoingAway tells the client the server is going away
+	closeGoingAway func()
+	jobs           map[string]struct{}
+}
+
+type pendingMessage struct {
+	message    Message
+	receivedAt time.Time
 }
 
 type websocketClient struct {
 	subscriptions map[*subscription]struct{}
+	pending       map[string]pendingMessage
+	pendingTTL    time.Duration
 	lock          sync.RWMutex
 	messageBuffer int
 	writeTimeout  func(ctx context.Context, timeout time.Duration, conn WebsocketConn, msg Message) error
+	read          func(ctx context.Context, conn WebsocketConn) (ClientMessage, error)
+	cancelJob     func(jobID string) error
+	// closed refuses new connections once the server is shutting down
+	closed bool
 }
 
-func DefaultwebsocketClient() *websocketClient {
+// DefaultwebsocketClient returns a client handing the cancel actions to
+// cancelJob.
+func DefaultwebsocketClient(cancelJob func(jobID string) error) *websocketClient {
 	return &websocketClient{
 		subscriptions: make(map[*subscription]struct{}),
+		pending:       make(map[string]pendingMessage),
+		pendingTTL:    time.Minute * 5,
 		messageBuffer: 16,
 		writeTimeout: func(ctx context.Context, timeout time.Duration, conn WebsocketConn, msg Message) error {
 			ctx, cancel := context.WithTimeout(ctx, timeout)
 			defer cancel()
 			return wsjson.Write(ctx, conn.(*websocket.Conn), msg)
 		},
+		read: func(ctx context.Context, conn WebsocketConn) (ClientMessage, error) {
+			var msg ClientMessage
+			err := wsjson.Read(ctx, conn.(*websocket.Conn), &msg)
+			return msg, err
+		},
+		cancelJob: cancelJob,
 	}
 }
 
 func (c *websocketClient) Handle(ctx context.Context, conn WebsocketConn) error {
-	ctx = conn.CloseRead(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	s := &subscription{
 		message: make(chan Message, c.messageBuffer),
 		closeSlow: func() {
 			conn.Close(websocket.StatusPolicyViolation, "connection too slow to keep up with messages")
 		},
+		closeGoingAway: func() {
+			conn.Close(websocket.StatusGoingAway, "server is shutting down")
+		},
+		jobs: make(map[string]struct{}),
 	}
 
-	c.addSubscription(s)
+	if !c.addSubscription(s) {
+		s.closeGoingAway()
+		return nil
+	}
 	defer c.removeSubscription(s)
 
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- c.readLoop(ctx, conn, s)
+	}()
+
 	for {
 		select {
 		case msg := <-s.message:
-			c.writeTimeout(ctx, time.Second*5, conn, msg)
-			return nil
+			err := c.writeTimeout(ctx, time.Second*5, conn, msg)
+			if err != nil {
+				return err
+			}
+
+		case err := <-readErr:
+			return err
 
 		case <-ctx.Done():
 			logs.Logger.Info("close websocket connection")
@@ -71,11 +130,42 @@ func (c *websocketClient) Handle(ctx context.Context, conn WebsocketConn) error
 	}
 }
 
-func (c *websocketClient) addSubscription(s *subscription) {
+func (c *websocketClient) readLoop(ctx context.Context, conn WebsocketConn, s *subscription) error {
+	for {
+		msg, err := c.read(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		switch msg.Action {
+		case ActionSubscribe:
+			c.subscribe(s, msg.JobID)
+		case ActionUnsubscribe:
+			c.unsubscribe(s, msg.JobID)
+		case ActionCancel:
+			// subscribed first, to hear about the cancellation
+			c.subscribe(s, msg.JobID)
+			if err := c.cancelJob(msg.JobID); err != nil {
+				logs.Logger.Warn("failed to cancel job", zap.String("job_id", msg.JobID), zap.Error(err))
+			}
+		default:
+			logs.Logger.Warn("unknown websocket action", zap.String("action", msg.Action))
+		}
+	}
+}
+
+// addSubscription fails once the client is shut down.
+func (c *websocketClient) addSubscription(s *subscription) bool {
 	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return false
+	}
+
 	c.subscriptions[s] = struct{}{}
-	c.lock.Unlock()
 	logs.Logger.Info("add subscription")
+	return true
 }
 
 func (c *websocketClient) removeSubscription(s *subscription) {
@@ -85,23 +175,101 @@ func (c *websocketClient) removeSubscription(s *subscription) {
 	logs.Logger.Info("remove Subscription")
 }
 
+// subscribe attaches jobID to s. If the job already finished before anyone
+// subscribed, the held back message is delivered right away.
+func (c *websocketClient) subscribe(s *subscription, jobID string) {
+	if jobID == "" {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s.jobs[jobID] = struct{}{}
+
+	pending, ok := c.pending[jobID]
+	if !ok {
+		return
+	}
+
+	delete(c.pending, jobID)
+	c.send(s, pending.message)
+}
+
+func (c *websocketClient) unsubscribe(s *subscription, jobID string) {
+	c.lock.Lock()
+	delete(s.jobs, jobID)
+	c.lock.Unlock()
+}
+
+// Shutdown closes every connection with the going away status, and the
+// ones opened afterwards right away.
+func (c *websocketClient) Shutdown() {
+	c.lock.Lock()
+	c.closed = true
+	subscriptions := make([]*subscription, 0, len(c.subscriptions))
+	for s := range c.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	c.lock.Unlock()
+
+	// every close waits for the client to answer it
+	var wg sync.WaitGroup
+	for _, s := range subscriptions {
+		wg.Add(1)
+		go func(s *subscription) {
+			defer wg.Done()
+			s.closeGoingAway()
+		}(s)
+	}
+	wg.Wait()
+}
+
 func (c *websocketClient) SubscriptionCount() int {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	return len(c.subscriptions)
 }
 
-func (c *websocketClient) Brodcast(msg Message) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
+// Publish delivers msg only to the subscriptions that subscribed to
+// msg.JobID. Messages for jobs nobody is listening to yet are held for
+// pendingTTL so a client that subscribes late does not miss them.
+func (c *websocketClient) Publish(msg Message) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delivered := false
 
 	for s := range c.subscriptions {
-		select {
-		case s.message <- msg:
-		default:
-			go s.closeSlow()
+		if _, ok := s.jobs[msg.JobID]; !ok {
+			continue
 		}
+
+		delivered = true
+		c.send(s, msg)
+	}
+
+	c.dropStalePending()
+
+	if !delivered {
+		c.pending[msg.JobID] = pendingMessage{message: msg, receivedAt: time.Now()}
 	}
 
-	logs.Logger.Info("Brodcast message to all subscriptions")
+	logs.Logger.Info("Publish message to job subscriptions", zap.String("job_id", msg.JobID), zap.Bool("delivered", delivered))
+}
+
+func (c *websocketClient) send(s *subscription, msg Message) {
+	select {
+	case s.message <- msg:
+	default:
+		go s.closeSlow()
+	}
+}
+
+func (c *websocketClient) dropStalePending() {
+	for jobID, pending := range c.pending {
+		if time.Since(pending.receivedAt) > c.pendingTTL {
+			delete(c.pending, jobID)
+		}
+	}
 }
@@ -10,15 +10,22 @@ import (
 	"nhooyr.io/websocket"
 )
 
-var hasWrite bool = false
-
-type StubWsConn struct{}
+type StubWsConn struct {
+	incoming chan ClientMessage
+	written  chan Message
+	closed   chan websocket.StatusCode
+}
 
-func (ws *StubWsConn) CloseRead(ctx context.Context) context.Context {
-	return ctx
+func NewStubWsConn() *StubWsConn {
+	return &StubWsConn{
+		incoming: make(chan ClientMessage, 10),
+		written:  make(chan Message, 10),
+		closed:   make(chan websocket.StatusCode, 10),
+	}
 }
 
 func (ws *StubWsConn) Close(code websocket.StatusCode, reason string) error {
+	ws.closed <- code
 	return nil
 
 }
@@ -26,68 +33,125 @@ func (ws *StubWsConn) Close(code websocket.StatusCode, reason string) error {
 func NewTestwebsocketClient(messageBuffer int) *websocketClient {
 	return &websocketClient{
 		subscriptions: make(map[*subscription]struct{}),
+		pending:       make(map[string]pendingMessage),
+		pendingTTL:    time.Minute,
 		messageBuffer: messageBuffer,
 		writeTimeout: func(ctx context.Context, timeout time.Duration, conn WebsocketConn, msg Message) error {
 			_, cancel := context.WithTimeout(ctx, timeout)
 			defer cancel()
-			hasWrite = true
+			conn.(*StubWsConn).written <- msg
 			return nil
 		},
+		read: func(ctx context.Context, conn WebsocketConn) (ClientMessage, error) {
+			select {
+			case msg := <-conn.(*StubWsConn).incoming:
+				return msg, nil
+			case <-ctx.Done():
+				return ClientMessage{}, ctx.Err()
+			}
+		},
+		cancelJob: func(jobID string) error { return nil },
 	}
 }
 
+func newTestSubscription(wsClient *websocketClient, jobs ...string) *subscription {
+	sub := &subscription{
+		message:   make(chan Message, wsClient.messageBuffer),
+		closeSlow: func() {},
+		jobs:      make(map[string]struct{}),
+	}
+
+	for _, job := range jobs {
+		sub.jobs[job] = struct{}{}
+	}
+
+	return sub
+}
+
 func TestHandle(t *testing.T) {
 	assert := assert.New(t)
 
 	ctx, cancel := context.WithCancel(context.Background())
-	wsConn := &StubWsConn{}
-
+	wsConn := NewStubWsConn()
 	wsClient := NewTestwebsocketClient(10)
 
-	sub := &subscription{
-		message: make(chan Message, wsClient.messageBuffer),
-	}
-	wsClient.addSubscription(sub)
-	defer wsClient.removeSubscription(sub)
-
 	var wg sync.WaitGroup
+	var err error
+
 	wg.Add(1)
 	go func() {
-		defer close(sub.message)
-		for sub := range wsClient.subscriptions {
-			sub.message <- Message{Action: "", DownloadUrl: ""}
-		}
-		wg.Done()
+		defer wg.Done()
+		err = wsClient.Handle(ctx, wsConn)
 	}()
 
-	err := wsClient.Handle(ctx, wsConn)
-	assert.NoError(err)
-	assert.True(hasWrite)
+	wsConn.incoming <- ClientMessage{Action: ActionSubscribe, JobID: "job-1"}
 
-	hasWrite = false
-	wg.Add(1)
-	go func() {
-		cancel()
-		wg.Done()
-	}()
+	assert.Eventually(func() bool {
+		wsClient.lock.RLock()
+		defer wsClient.lock.RUnlock()
+		for s := range wsClient.subscriptions {
+			if _, ok := s.jobs["job-1"]; ok {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond*10)
 
-	err = wsClient.Handle(ctx, wsConn)
-	assert.Equal(err, context.Canceled)
-	assert.False(hasWrite)
+	msg := Message{Action: "processing_complete", JobID: "job-1"}
+	wsClient.Publish(msg)
+	assert.Equal(msg, <-wsConn.written)
 
+	cancel()
+	wg.Wait()
+
+	assert.Equal(context.Canceled, err)
+	assert.Equal(0, wsClient.SubscriptionCount())
 }
 
-func TestBroadcast(t *testing.T) {
+func TestHandleCancel(t *testing.T) {
 	assert := assert.New(t)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wsConn := NewStubWsConn()
 	wsClient := NewTestwebsocketClient(10)
-	sub1 := &subscription{
-		message: make(chan Message, wsClient.messageBuffer),
+	wsClient.cancelJob = func(jobID string) error {
+		wsClient.Publish(Message{Action: "processing_cancelled", JobID: jobID})
+		return nil
 	}
 
-	sub2 := &subscription{
-		message: make(chan Message, wsClient.messageBuffer),
-	}
+	go wsClient.Handle(ctx, wsConn)
+
+	wsConn.incoming <- ClientMessage{Action: ActionCancel, JobID: "job-1"}
+	assert.Equal(Message{Action: "processing_cancelled", JobID: "job-1"}, <-wsConn.written)
+}
+
+func TestHandleShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	wsConn := NewStubWsConn()
+	wsClient := NewTestwebsocketClient(10)
+
+	go wsClient.Handle(context.Background(), wsConn)
+	assert.Eventually(func() bool { return wsClient.SubscriptionCount() == 1 }, time.Second, time.Millisecond)
+
+	wsClient.Shutdown()
+	assert.Equal(websocket.StatusGoingAway, <-wsConn.closed)
+
+	// connections opened afterwards are turned away
+	lateConn := NewStubWsConn()
+	assert.NoError(wsClient.Handle(context.Background(), lateConn))
+	assert.Equal(websocket.StatusGoingAway, <-lateConn.closed)
+}
+
+func TestPublish(t *testing.T) {
+	assert := assert.New(t)
+
+	wsClient := NewTestwebsocketClient(10)
+	sub1 := newTestSubscription(wsClient, "job-1")
+	sub2 := newTestSubscription(wsClient, "job-2")
+
 	wsClient.addSubscription(sub1)
 	wsClient.addSubscription(sub2)
 
@@ -96,9 +160,26 @@ func TestBroadcast(t *testing.T) {
 		wsClient.removeSubscription(sub2)
 	}()
 
-	msg := Message{Action: "", DownloadUrl: ""}
-	wsClient.Brodcast(msg)
+	msg := Message{Action: "processing_complete", JobID: "job-1"}
+	wsClient.Publish(msg)
 
 	assert.Equal(msg, <-sub1.message)
-	assert.Equal(msg, <-sub2.message)
+	assert.Len(sub2.message, 0)
+}
+
+func TestPublishBeforeSubscribe(t *testing.T) {
+	assert := assert.New(t)
+
+	wsClient := NewTestwebsocketClient(10)
+	sub := newTestSubscription(wsClient)
+	wsClient.addSubscription(sub)
+	defer wsClient.removeSubscription(sub)
+
+	msg := Message{Action: "processing_complete", JobID: "job-1"}
+	wsClient.Publish(msg)
+	assert.Len(sub.message, 0)
+
+	wsClient.subscribe(sub, "job-1")
+	assert.Equal(msg, <-sub.message)
+	assert.Len(wsClient.pending, 0)
 }
@@ -11,11 +11,14 @@ type erroMesage struct {
 
 type httpServer struct {
 	mux *http.ServeMux
+	// routes holds the handler of every method registered for a pattern
+	routes map[string]map[string]http.HandlerFunc
 }
 
 func NewHttpServer() *httpServer {
 	return &httpServer{
-		mux: http.NewServeMux(),
+		mux:    http.NewServeMux(),
+		routes: make(map[string]map[string]http.HandlerFunc),
 	}
 }
 
@@ -23,10 +26,11 @@ func (s *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
-func (s *httpServer) intercept(pattern string, method string, next http.HandlerFunc) http.HandlerFunc {
+func (s *httpServer) intercept(methods map[string]http.HandlerFunc) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		if method != r.Method {
+		next, ok := methods[r.Method]
+		if !ok {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			json.NewEncoder(w).Encode(erroMesage{Message: "Method not allowed"})
@@ -37,12 +41,27 @@ func (s *httpServer) intercept(pattern string, method string, next http.HandlerF
 	}
 }
 
+// handle registers handler for method on pattern, next to the handlers of
+// the other methods of the pattern.
+func (s *httpServer) handle(pattern string, method string, handler http.HandlerFunc) {
+	methods, ok := s.routes[pattern]
+	if !ok {
+		methods = make(map[string]http.HandlerFunc)
+		s.routes[pattern] = methods
+		s.mux.HandleFunc(pattern, s.intercept(methods))
+	}
+
+	methods[method] = handler
+}
+
 func (s *httpServer) Post(pattern string, handler func(w http.ResponseWriter, r *http.Request)) {
-	handlerFunc := s.intercept(pattern, http.MethodPost, handler)
-	s.mux.HandleFunc(pattern, handlerFunc)
+	s.handle(pattern, http.MethodPost, handler)
 }
 
 func (s *httpServer) Get(pattern string, handler func(w http.ResponseWriter, r *http.Request)) {
-	handlerFunc := s.intercept(pattern, http.MethodGet, handler)
-	s.mux.HandleFunc(pattern, handlerFunc)
+	s.handle(pattern, http.MethodGet, handler)
+}
+
+func (s *httpServer) Delete(pattern string, handler func(w http.ResponseWriter, r *http.Request)) {
+	s.handle(pattern, http.MethodDelete, handler)
 }